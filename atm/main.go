@@ -1,42 +1,189 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"github.com/rickducott/techproblems/atm/pkg"
+	"io"
+	"net"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
 	AccountData = []pkg.Account{
-		pkg.NewAccount("2859459814", "7386", pkg.NewAmount(10, 24)),
-		pkg.NewAccount("1434597300", "4557", pkg.NewAmount(90000, 55)),
-		pkg.NewAccount("7089382418", "0075", pkg.ZeroAmount),
-		pkg.NewAccount("2001377812", "5950", pkg.NewAmount(60, 0)),
+		pkg.NewAccount(pkg.SystemClock, "2859459814", "7386", pkg.NewAmount(10, 24), pkg.DefaultOverdraftPolicy),
+		pkg.NewAccount(pkg.SystemClock, "1434597300", "4557", pkg.NewAmount(90000, 55), pkg.DefaultOverdraftPolicy),
+		pkg.NewAccount(pkg.SystemClock, "7089382418", "0075", pkg.ZeroAmount, pkg.DefaultOverdraftPolicy),
+		pkg.NewAccount(pkg.SystemClock, "2001377812", "5950", pkg.NewAmount(60, 0), pkg.DefaultOverdraftPolicy),
 	}
 
-	LogoutSeconds = 120
+	CassetteData = []pkg.Cassette{
+		{Denomination: pkg.Dollars(100), Count: 20},
+		{Denomination: pkg.Dollars(50), Count: 40},
+		{Denomination: pkg.Dollars(20), Count: 250},
+		{Denomination: pkg.Dollars(10), Count: 80},
+		{Denomination: pkg.Dollars(5), Count: 40},
+		// Only dispensed when the atm is given exchange rates with -rates.
+		{Denomination: pkg.MajorAmount(pkg.EUR, 50), Count: 40},
+		{Denomination: pkg.MajorAmount(pkg.EUR, 20), Count: 100},
+	}
+
+	TerminalId     = "ATM-0001"
+	LogoutSeconds  = 120
+	WarningSeconds = 30
+
+	JournalCompactAfter = 1000
+
+	// How long to let requests in progress finish when the server is asked to shut down.
+	ShutdownTimeout = 10 * time.Second
+
+	ServeAddr  = ":8080"
+	ListenAddr = ":2323"
 )
 
+// Run as `main [flags]` to use the atm from the terminal, `main serve [flags]` to serve it as a
+// JSON API over HTTP, or `main listen [flags]` to serve the terminal's command language over TCP.
 func main() {
-	atm, done := pkg.NewAtm(LogoutSeconds, AccountData...)
-	textUi := pkg.NewInterface(atm)
-	reader := bufio.NewReader(os.Stdin)
-	end := false
-	for !end {
-		fmt.Printf("> ")
-		line, err := reader.ReadString('\n')
+	args := os.Args[1:]
+	mode := "text"
+	if len(args) > 0 && (args[0] == "serve" || args[0] == "listen") {
+		mode = args[0]
+		args = args[1:]
+	}
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	accountsFile := flags.String("accounts", "", "JSON file to load accounts from and save them to (seeded with the demo accounts if it doesn't exist)")
+	journalFile := flags.String("journal", "", "journal file to record every transaction in, and replay on startup")
+	addr := flags.String("addr", "", "address to listen on when serving (default "+ServeAddr+" for serve, "+ListenAddr+" for listen)")
+	maxConnections := flags.Int("max-connections", 100, "most connections to serve at once when listening; 0 for no limit")
+	jsonOutput := flags.Bool("json", false, "reply to commands with JSON instead of text when using the terminal or listening")
+	ratesFile := flags.String("rates", "", "JSON file of exchange rates, to dispense cash in other currencies than the account's")
+	spread := flags.Int("spread", 250, "margin over the exchange rate, in basis points")
+	idleTimeout := flags.Duration("idle-timeout", 5*time.Minute, "close connections that send nothing for this long when listening; 0 for never")
+	_ = flags.Parse(args)
+	if *accountsFile != "" && *journalFile != "" {
+		fmt.Printf("-journal can't be used with -accounts, which keeps its own journal in %s.journal\n", *accountsFile)
+		os.Exit(1)
+	}
+
+	accounts, err := openAccounts(*accountsFile)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+	var journal pkg.Journal
+	if *journalFile != "" {
+		journal, err = pkg.OpenJournal(pkg.SystemClock, *journalFile, JournalCompactAfter)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(1)
+		}
+		defer journal.Close()
+	}
+	var exchange *pkg.Exchange
+	if *ratesFile != "" {
+		rates, err := pkg.OpenRateFile(*ratesFile)
 		if err != nil {
 			fmt.Printf("%s\n", err.Error())
-			end = true
-		} else if strings.TrimSpace(line) == "end" {
-			end = true
-		} else {
-			output := textUi.Execute(line)
-			fmt.Printf("%s\n", output)
+			os.Exit(1)
 		}
+		exchange = &pkg.Exchange{Rates: rates, SpreadBasisPoints: *spread}
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
 
-	done <-true
-}
\ No newline at end of file
+	// The atm outlives the signal, so whatever is in progress when it arrives can finish before
+	// the atm is closed.
+	atm, err := pkg.NewAtmWithExchange(context.Background(), pkg.SystemClock, TerminalId, LogoutSeconds, WarningSeconds, pkg.NewCassetteDispenser(CassetteData...), accounts, journal, exchange)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+	format := pkg.TextFormat
+	if *jsonOutput {
+		format = pkg.JsonFormat
+	}
+	switch mode {
+	case "serve":
+		err = serve(ctx, atm, orDefault(*addr, ServeAddr))
+	case "listen":
+		err = listen(ctx, pkg.NewLineServer(atm, format, *maxConnections, *idleTimeout), orDefault(*addr, ListenAddr))
+	default:
+		err = pkg.RunTerminal(ctx, atm, format, os.Stdin, os.Stdout)
+	}
+	if err == io.EOF && format == pkg.JsonFormat {
+		// Running out of input is how scripts end their session, and every line out must be JSON.
+		err = nil
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+	}
+	if err := atm.Close(); err != nil {
+		fmt.Printf("%s\n", err.Error())
+	}
+}
+
+// Serve the atm over HTTP until the context is done, then let requests in progress finish.
+func serve(ctx context.Context, atm pkg.Atm, addr string) error {
+	server := &http.Server{Addr: addr, Handler: pkg.NewServer(atm)}
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(shutdownCtx)
+	}()
+	fmt.Printf("Serving on %s\n", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
+}
+
+// Serve the atm's command language over TCP until the context is done.
+func listen(ctx context.Context, server *pkg.LineServer, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Listening on %s\n", listener.Addr())
+	return server.Serve(ctx, listener)
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func openAccounts(path string) (pkg.AccountStore, error) {
+	if path == "" {
+		return pkg.NewMemoryStore(AccountData...), nil
+	}
+	store, err := pkg.OpenFileStore(pkg.SystemClock, path)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		for _, account := range AccountData {
+			if err := store.Save(account); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return store, nil
+}
@@ -1,37 +1,143 @@
 package pkg
 
 import (
-	"errors"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
 )
 
 var (
-	AccountOverdrawnError = errors.New("Your account is overdrawn! You may not make withdrawals at this time.")
+	AccountOverdrawnError  = NewATMError("account_overdrawn", FundsCategory, "Your account is overdrawn! You may not make withdrawals at this time.")
+	InsufficientFundsError = NewATMError("insufficient_funds", FundsCategory, "Insufficient funds for this withdrawal.")
+	OverdraftLimitError    = NewATMError("overdraft_limit", FundsCategory, "This withdrawal would exceed your overdraft limit.")
 
-	OverdraftFee = Dollars(5)
+	OverdraftFeeMemo = "overdraft fee"
 
 	_ Account = new(account)
 )
 
 type Account interface {
 	GetId() string
-	Transaction(amount Amount) (*Transaction, error)
+	// The currency the account is kept in. Every posting to it must be in the same currency.
+	Currency() Currency
+	Transaction(amount Amount, details TransactionDetails) (*Transaction, error)
+	// Work out the transaction that Transaction would post, fees included, without changing the
+	// account. Nothing else may be posted to the account until it's committed.
+	Prepare(amount Amount, details TransactionDetails) (*Transaction, error)
+	// Record a transaction from Prepare and its fees in the journal, if the account has one, then
+	// apply them. If they can't be recorded, the account is left as it was.
+	Commit(txn *Transaction) error
 	Balance() Amount
+	Policy() OverdraftPolicy
 	History() []Transaction
 	Authorize(pin string) bool
+	Snapshot() AccountSnapshot
+	// Apply a posting that has already been checked, e.g. one replayed from a journal.
+	Apply(txn Transaction)
+	// Record every future posting in the journal before applying it.
+	SetJournal(journal Journal)
 }
 
-func NewAccount(id, pin string, balance Amount) Account {
+// Everything needed to persist an account and restore it later.
+type AccountSnapshot struct {
+	Id string
+	// A salted hash of the account's PIN; the PIN itself is never kept. Only the stores write it
+	// out, so an account restored from a snapshot's JSON can't be authorized.
+	PinHash  string
+	Currency Currency
+	Balance  Amount
+	Policy   OverdraftPolicy
+	History  []Transaction
+}
+
+// Open an account in the currency of its opening balance, or in DefaultCurrency if the balance is
+// ZeroAmount. Use Zero to open an empty account in another currency. Like Add, panics with a
+// CurrencyMismatchError if the policy's amounts are in another currency, as DefaultOverdraftPolicy's
+// are for anything but dollars, since no posting could be checked against it.
+func NewAccount(clock Clock, id, pin string, balance Amount, policy OverdraftPolicy) Account {
+	currency := accountCurrency("", balance)
+	if err := policy.checkCurrency(currency); err != nil {
+		panic(err)
+	}
+	return &account{
+		id:       id,
+		pinHash:  hashPin(pin),
+		currency: currency,
+		balance:  Zero(currency).Add(balance),
+		policy:   policy,
+		clock:    clock,
+	}
+}
+
+// Rebuild an account from a snapshot taken with Account.Snapshot. Like NewAccount, panics with a
+// CurrencyMismatchError if the policy is in another currency; check snapshots read from outside
+// with Validate first.
+func RestoreAccount(clock Clock, snapshot AccountSnapshot) Account {
+	if err := snapshot.Validate(); err != nil {
+		panic(err)
+	}
+	currency := accountCurrency(snapshot.Currency, snapshot.Balance)
 	return &account{
-		id:      id,
-		pin:     pin,
-		balance: balance,
+		id:           snapshot.Id,
+		pinHash:      snapshot.PinHash,
+		currency:     currency,
+		balance:      Zero(currency).Add(snapshot.Balance),
+		policy:       snapshot.Policy,
+		clock:        clock,
+		transactions: append([]Transaction(nil), snapshot.History...),
 	}
 }
 
+// Check that the snapshot's balance, policy and history are all in the account's currency.
+func (s AccountSnapshot) Validate() error {
+	currency := accountCurrency(s.Currency, s.Balance)
+	if !Zero(currency).SameCurrency(s.Balance) {
+		return currencyMismatchError(currency, s.Balance.Currency())
+	}
+	if err := s.Policy.checkCurrency(currency); err != nil {
+		return err
+	}
+	for _, txn := range s.History {
+		if err := checkPostingCurrency(currency, txn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check that a posting's amount and balance are in the account's currency.
+func checkPostingCurrency(currency Currency, txn Transaction) error {
+	for _, amount := range []Amount{txn.Amount, txn.Balance} {
+		if !Zero(currency).SameCurrency(amount) {
+			return currencyMismatchError(currency, amount.Currency())
+		}
+	}
+	return nil
+}
+
+// The declared currency if there is one, otherwise the balance's, otherwise DefaultCurrency.
+func accountCurrency(declared Currency, balance Amount) Currency {
+	if declared != "" {
+		return declared
+	}
+	if balance.Currency() != "" {
+		return balance.Currency()
+	}
+	return DefaultCurrency
+}
+
 type account struct {
 	id           string
-	pin          string
+	pinHash      string
+	currency     Currency
 	balance      Amount
+	policy       OverdraftPolicy
+	clock        Clock
+	journal      Journal
 	transactions []Transaction
 }
 
@@ -39,30 +145,116 @@ func (a *account) GetId() string {
 	return a.id
 }
 
-func (a *account) Transaction(amount Amount) (*Transaction, error) {
-	if ZeroAmount.GreaterThan(amount) && ZeroAmount.GreaterThan(a.balance) {
-		return nil, AccountOverdrawnError
+// Post the amount to the account, followed by any overdraft fee it incurs. Withdrawals made at an
+// atm should pass along the notes that were dispensed, so they're recorded on the transaction.
+// Postings that would take the balance out of range fail with an AmountRangeError.
+func (a *account) Transaction(amount Amount, details TransactionDetails) (*Transaction, error) {
+	transaction, err := a.Prepare(amount, details)
+	if err != nil {
+		return nil, err
 	}
-	a.balance = a.balance.Add(amount)
-	transaction := NewTransaction(amount, a.balance)
-	if transaction.Overdraft {
-		a.balance = a.balance.Subtract(OverdraftFee)
-		transaction = NewTransaction(amount, a.balance)
+	if err := a.Commit(transaction); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+func (a *account) Prepare(amount Amount, details TransactionDetails) (*Transaction, error) {
+	if !Zero(a.currency).SameCurrency(amount) {
+		return nil, currencyMismatchError(a.currency, amount.Currency())
+	}
+	balance, err := a.balance.AddChecked(amount)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := a.policy.Charge(a.balance, amount, a.feesCharged(a.clock.Now()))
+	if err != nil {
+		return nil, err
+	}
+	transaction := NewTransaction(a.clock, amount, balance, details)
+	if fee.GreaterThan(ZeroAmount) {
+		if balance, err = balance.SubtractChecked(fee); err != nil {
+			return nil, err
+		}
+		transaction.Fees = append(transaction.Fees, NewTransaction(a.clock, fee.Negative(), balance, TransactionDetails{
+			Type:       FeeTransaction,
+			ParentId:   transaction.Id,
+			TerminalId: details.TerminalId,
+			Memo:       OverdraftFeeMemo,
+		}))
 	}
-	a.transactions = append(a.transactions, transaction)
 	return &transaction, nil
 }
 
+func (a *account) Commit(txn *Transaction) error {
+	postings := txn.Postings()
+	if a.journal != nil {
+		if err := a.journal.Record(a.id, postings); err != nil {
+			return err
+		}
+	}
+	for _, posting := range postings {
+		a.Apply(posting)
+	}
+	return nil
+}
+
+func (a *account) Apply(txn Transaction) {
+	a.balance = txn.Balance
+	a.transactions = append(a.transactions, txn)
+}
+
+func (a *account) SetJournal(journal Journal) {
+	a.journal = journal
+}
+
+// Total fees charged on the same day as the given time, less any of them that have been refunded.
+func (a *account) feesCharged(day time.Time) Amount {
+	year, month, date := day.Date()
+	fees := ZeroAmount
+	charged := make(map[string]bool)
+	for _, transaction := range a.transactions {
+		y, m, d := transaction.Date.Date()
+		switch {
+		case transaction.Type == FeeTransaction && y == year && m == month && d == date:
+			fees = fees.Add(transaction.Amount.Abs())
+			charged[transaction.Id] = true
+		case transaction.Type == ReversalTransaction && charged[transaction.ParentId]:
+			fees = fees.Subtract(transaction.Amount)
+		}
+	}
+	return fees
+}
+
+func (a *account) Currency() Currency {
+	return a.currency
+}
+
 func (a *account) Balance() Amount {
 	return a.balance
 }
 
+func (a *account) Policy() OverdraftPolicy {
+	return a.policy
+}
+
 func (a *account) History() []Transaction {
 	return a.transactions
 }
 
 func (a *account) Authorize(pin string) bool {
-	return a.pin == pin
+	return checkPin(a.pinHash, pin)
+}
+
+func (a *account) Snapshot() AccountSnapshot {
+	return AccountSnapshot{
+		Id:       a.id,
+		PinHash:  a.pinHash,
+		Currency: a.currency,
+		Balance:  a.balance,
+		Policy:   a.policy,
+		History:  append([]Transaction(nil), a.transactions...),
+	}
 }
 
 func Accounts(accounts ...Account) map[string]Account {
@@ -72,3 +264,31 @@ func Accounts(accounts ...Account) map[string]Account {
 	}
 	return accountMap
 }
+
+// A PIN's hash, written as "sha256:<salt>:<digest>" in hex. The salt is random, so accounts with
+// the same PIN don't share a hash.
+func hashPin(pin string) string {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return saltedPinHash(salt, pin)
+}
+
+func saltedPinHash(salt []byte, pin string) string {
+	digest := sha256.Sum256(append(append([]byte(nil), salt...), pin...))
+	return fmt.Sprintf("sha256:%x:%x", salt, digest)
+}
+
+// Whether the PIN is the one hashed by hashPin. An empty or malformed hash matches no PIN.
+func checkPin(hash, pin string) bool {
+	parts := strings.Split(hash, ":")
+	if len(parts) != 3 || parts[0] != "sha256" {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(saltedPinHash(salt, pin)), []byte(hash)) == 1
+}
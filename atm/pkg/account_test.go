@@ -1,6 +1,8 @@
 package pkg_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rickducott/techproblems/atm/pkg"
@@ -9,14 +11,16 @@ import (
 var _ = Describe("Account", func() {
 	var (
 		account pkg.Account
+		clock   *pkg.ManualClock
 	)
 
 	BeforeEach(func() {
-		account = pkg.NewAccount("12345", "1234", pkg.Cents(10000))
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account = pkg.NewAccount(clock, "12345", "1234", pkg.Cents(10000), pkg.DefaultOverdraftPolicy)
 	})
 
 	It("works when depositing", func() {
-		txn, err := account.Transaction(pkg.Cents(1000))
+		txn, err := account.Transaction(pkg.Cents(1000), pkg.TransactionDetails{})
 		Expect(err).To(BeNil())
 		Expect(txn.Balance).To(Equal(pkg.Cents(11000)))
 		Expect(txn.Amount).To(Equal(pkg.Cents(1000)))
@@ -24,7 +28,7 @@ var _ = Describe("Account", func() {
 	})
 
 	It("works when withdrawing", func() {
-		txn, err := account.Transaction(pkg.Cents(-1000))
+		txn, err := account.Transaction(pkg.Cents(-1000), pkg.TransactionDetails{})
 		Expect(err).To(BeNil())
 		Expect(txn.Balance).To(Equal(pkg.Cents(9000)))
 		Expect(txn.Amount).To(Equal(pkg.Cents(-1000)))
@@ -32,27 +36,155 @@ var _ = Describe("Account", func() {
 	})
 
 	It("handles overdrafts", func() {
-		txn, err := account.Transaction(pkg.Cents(-12000))
+		txn, err := account.Transaction(pkg.Cents(-12000), pkg.TransactionDetails{})
 		Expect(err).To(BeNil())
-		Expect(txn.Balance).To(Equal(pkg.Cents(-2000).Subtract(pkg.OverdraftFee)))
+		Expect(txn.Balance).To(Equal(pkg.Cents(-2000)))
 		Expect(txn.Amount).To(Equal(pkg.Cents(-12000)))
 		Expect(txn.Overdraft).To(BeTrue())
+		Expect(txn.Fees).To(HaveLen(1))
+		Expect(txn.Fees[0].Type).To(Equal(pkg.FeeTransaction))
+		Expect(txn.Fees[0].ParentId).To(Equal(txn.Id))
+		Expect(txn.Fees[0].Amount).To(Equal(pkg.Dollars(-5)))
+		Expect(txn.ClosingBalance()).To(Equal(pkg.Cents(-2000).Subtract(pkg.Dollars(5))))
+		Expect(account.Balance()).To(Equal(txn.ClosingBalance()))
+	})
+
+	It("records fees as separate history entries", func() {
+		_, _ = account.Transaction(pkg.Cents(-12000), pkg.TransactionDetails{})
+		history := account.History()
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].Type).To(Equal(pkg.WithdrawalTransaction))
+		Expect(history[1].Type).To(Equal(pkg.FeeTransaction))
+		Expect(history[1].Memo).To(Equal(pkg.OverdraftFeeMemo))
+		Expect(history[1].ParentId).To(Equal(history[0].Id))
+		balance := pkg.Cents(10000)
+		for _, txn := range history {
+			balance = balance.Add(txn.Amount)
+			Expect(txn.Balance).To(Equal(balance))
+		}
 	})
 
 	It("returns error if already overdrawn", func() {
-		_, err := account.Transaction(pkg.Cents(-12000))
+		_, err := account.Transaction(pkg.Cents(-12000), pkg.TransactionDetails{})
 		Expect(err).To(BeNil())
-		_, err = account.Transaction(pkg.Cents(-10000))
+		_, err = account.Transaction(pkg.Cents(-10000), pkg.TransactionDetails{})
 		Expect(err).To(Equal(pkg.AccountOverdrawnError))
 	})
 
+	It("caps overdraft fees per day", func() {
+		account = pkg.NewAccount(clock, "12345", "1234", pkg.Cents(10000), pkg.OverdraftPolicy{
+			AllowWhileOverdrawn: true,
+			Fees:                pkg.FlatFee(pkg.Dollars(30)),
+			DailyCap:            pkg.Dollars(50),
+		})
+		txn, err := account.Transaction(pkg.Dollars(-120), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(txn.Fees[0].Amount).To(Equal(pkg.Dollars(-30)))
+		txn, err = account.Transaction(pkg.Dollars(-20), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(txn.Fees[0].Amount).To(Equal(pkg.Dollars(-20)))
+		txn, err = account.Transaction(pkg.Dollars(-20), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(txn.Fees).To(BeEmpty())
+		Expect(account.Balance()).To(Equal(pkg.Dollars(-110)))
+
+		clock.Advance(24 * time.Hour)
+		txn, err = account.Transaction(pkg.Dollars(-20), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(txn.Fees[0].Amount).To(Equal(pkg.Dollars(-30)))
+		Expect(txn.Date).To(Equal(clock.Now()))
+	})
+
+	It("doesn't count refunded fees towards the daily cap", func() {
+		account = pkg.NewAccount(clock, "12345", "1234", pkg.Cents(10000), pkg.OverdraftPolicy{
+			AllowWhileOverdrawn: true,
+			Fees:                pkg.FlatFee(pkg.Dollars(30)),
+			DailyCap:            pkg.Dollars(50),
+		})
+		txn, err := account.Transaction(pkg.Dollars(-120), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		_, err = account.Transaction(pkg.Dollars(120), pkg.TransactionDetails{Type: pkg.ReversalTransaction, ParentId: txn.Id})
+		Expect(err).To(BeNil())
+		_, err = account.Transaction(pkg.Dollars(30), pkg.TransactionDetails{Type: pkg.ReversalTransaction, ParentId: txn.Fees[0].Id})
+		Expect(err).To(BeNil())
+		Expect(account.Balance()).To(Equal(pkg.Dollars(100)))
+
+		txn, err = account.Transaction(pkg.Dollars(-120), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(txn.Fees[0].Amount).To(Equal(pkg.Dollars(-30)))
+	})
+
+	It("records transaction details", func() {
+		txn, err := account.Transaction(pkg.Cents(-1000), pkg.TransactionDetails{
+			TerminalId: "ATM-1",
+			Memo:       "groceries",
+		})
+		Expect(err).To(BeNil())
+		Expect(txn.Id).NotTo(BeEmpty())
+		Expect(txn.Type).To(Equal(pkg.WithdrawalTransaction))
+		Expect(txn.TerminalId).To(Equal("ATM-1"))
+		Expect(txn.Memo).To(Equal("groceries"))
+		Expect(txn.String()).To(HaveSuffix(txn.Id + ` withdrawal -10.00 90.00 terminal=ATM-1 memo="groceries"`))
+
+		reversal, err := account.Transaction(pkg.Cents(1000), pkg.TransactionDetails{
+			Type:     pkg.ReversalTransaction,
+			ParentId: txn.Id,
+		})
+		Expect(err).To(BeNil())
+		Expect(reversal.Id).NotTo(Equal(txn.Id))
+		Expect(reversal.Type).To(Equal(pkg.ReversalTransaction))
+		Expect(reversal.String()).To(HaveSuffix("reversal 10.00 100.00 parent=" + txn.Id))
+	})
+
 	It("updates history and balance", func() {
-		_, _ = account.Transaction(pkg.Cents(-1000))
-		_, _ = account.Transaction(pkg.Cents(-2000))
-		_, _ = account.Transaction(pkg.Cents(-3000))
+		_, _ = account.Transaction(pkg.Cents(-1000), pkg.TransactionDetails{})
+		_, _ = account.Transaction(pkg.Cents(-2000), pkg.TransactionDetails{})
+		_, _ = account.Transaction(pkg.Cents(-3000), pkg.TransactionDetails{})
 		Expect(account.Balance()).To(Equal(pkg.Cents(4000)))
 		Expect(account.History()).Should(HaveLen(3))
 		Expect(account.History()[0].Amount).To(Equal(pkg.Cents(-1000)))
+		Expect(account.History()[0].Type).To(Equal(pkg.WithdrawalTransaction))
 		Expect(account.History()[2].Amount).To(Equal(pkg.Cents(-3000)))
 	})
+
+	It("prepares transactions without posting them", func() {
+		txn, err := account.Prepare(pkg.Dollars(-150), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(txn.Fees).To(HaveLen(1))
+		Expect(account.Balance()).To(Equal(pkg.Cents(10000)))
+		Expect(account.History()).To(BeEmpty())
+		Expect(account.Commit(txn)).To(Succeed())
+		Expect(account.Balance()).To(Equal(pkg.Dollars(-55)))
+		Expect(account.History()).To(Equal(txn.Postings()))
+	})
+
+	It("keeps to its currency", func() {
+		Expect(account.Currency()).To(Equal(pkg.USD))
+		euros := pkg.NewAccount(clock, "67890", "1234", pkg.Zero(pkg.EUR), pkg.OverdraftPolicy{
+			Fees: pkg.FlatFee(pkg.MajorAmount(pkg.EUR, 5)),
+		})
+		Expect(euros.Currency()).To(Equal(pkg.EUR))
+		_, err := euros.Transaction(pkg.Dollars(10), pkg.TransactionDetails{})
+		Expect(err).To(MatchError(pkg.CurrencyMismatchError))
+		txn, err := euros.Transaction(pkg.MajorAmount(pkg.EUR, -10), pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		Expect(euros.Balance()).To(Equal(pkg.MajorAmount(pkg.EUR, -15)))
+		Expect(txn.Fees[0].Amount).To(Equal(pkg.MajorAmount(pkg.EUR, -5)))
+	})
+
+	It("won't open with a policy in another currency", func() {
+		Expect(func() {
+			pkg.NewAccount(clock, "67890", "1234", pkg.MajorAmount(pkg.EUR, 10), pkg.DefaultOverdraftPolicy)
+		}).To(PanicWith(MatchError(pkg.CurrencyMismatchError)))
+		snapshot := pkg.AccountSnapshot{Id: "67890", Currency: pkg.EUR, Policy: pkg.DefaultOverdraftPolicy}
+		Expect(snapshot.Validate()).To(MatchError(pkg.CurrencyMismatchError))
+		Expect(func() { pkg.RestoreAccount(clock, snapshot) }).To(Panic())
+	})
+
+	It("refuses postings that would take the balance out of range", func() {
+		_, err := account.Transaction(pkg.Cents(pkg.MaxMinorUnits), pkg.TransactionDetails{})
+		Expect(err).To(MatchError(pkg.AmountRangeError))
+		Expect(account.Balance()).To(Equal(pkg.Cents(10000)))
+		Expect(account.History()).To(BeEmpty())
+	})
 })
@@ -7,33 +7,188 @@ import (
 	"strings"
 )
 
-var (
-	CentsPerDollar = 100
-	ZeroAmount     = Amount{}
+// An ISO 4217 currency code, such as "USD".
+type Currency string
+
+const (
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	CHF Currency = "CHF"
+	JPY Currency = "JPY"
+	KWD Currency = "KWD"
+)
 
-	AmountParseError = func(msg string) error {
-		return errors.New("Error parsing amount: " + msg)
+// What the package needs to know about a currency.
+type CurrencyInfo struct {
+	// How many digits come after the decimal point: 2 for cents, 0 for yen, 3 for fils.
+	MinorUnits int
+	Symbol     string
+}
+
+var (
+	// The currencies amounts can be parsed in. Add a site's own before parsing any amounts.
+	Currencies = map[Currency]CurrencyInfo{
+		USD: {MinorUnits: 2, Symbol: "$"},
+		EUR: {MinorUnits: 2, Symbol: "€"},
+		GBP: {MinorUnits: 2, Symbol: "£"},
+		CHF: {MinorUnits: 2, Symbol: "CHF"},
+		JPY: {MinorUnits: 0, Symbol: "¥"},
+		KWD: {MinorUnits: 3, Symbol: "KD"},
 	}
+
+	// The currency of amounts that don't say otherwise, such as those made by Dollars or parsed by
+	// ParseAmount, and of accounts opened with a zero balance.
+	DefaultCurrency = USD
+
+	// Zero, in no currency in particular, so it can be added to or compared with any amount.
+	ZeroAmount = Amount{}
+
+	AmountParseError      = NewATMError("amount_parse_error", InputCategory, "Error parsing amount.")
+	AmountRangeError      = NewATMError("amount_out_of_range", InputCategory, "The amount is too large.")
+	UnknownCurrencyError  = NewATMError("unknown_currency", InputCategory, "Unknown currency.")
+	CurrencyMismatchError = NewATMError("currency_mismatch", InputCategory, "The amount is in the wrong currency.")
 )
 
-// Create a new amount from a combination of dollars and cents.
+// The most minor units an amount can hold either way: just under ten quadrillion dollars, in cents.
+// Keeping well inside int64 means two amounts in range can always be added or subtracted without
+// wrapping, so the result can be checked, and every amount in range can be negated.
+const MaxMinorUnits int64 = 999999999999999999
+
+// Whether a count of minor units is within the supported range.
+func inRange(units int64) bool {
+	return units <= MaxMinorUnits && units >= -MaxMinorUnits
+}
+
+// a+b, or false if the sum wraps or falls outside the supported range.
+func addMinor(a, b int64) (int64, bool) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, false
+	}
+	return sum, inRange(sum)
+}
+
+// a*b, or false if the product wraps or falls outside the supported range.
+func mulMinor(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	product := a * b
+	if product/b != a {
+		return 0, false
+	}
+	return product, inRange(product)
+}
+
+// An AmountRangeError for an amount that doesn't fit.
+func amountRangeError(detail string) *ATMError {
+	return AmountRangeError.WithDetail(detail)
+}
+
+// An AmountParseError saying what was wrong, and the error behind it if there is one.
+func amountParseError(msg string, cause error) error {
+	return AmountParseError.WithMessage("Error parsing amount: " + msg).WithCause(cause)
+}
+
+// A CurrencyMismatchError saying which currencies were mixed.
+func currencyMismatchError(expected, actual Currency) *ATMError {
+	return CurrencyMismatchError.WithDetail(fmt.Sprintf("(expected %s, got %s)", expected, actual))
+}
+
+// The currency for a code such as "eur", ignoring case.
+func ParseCurrency(code string) (Currency, error) {
+	currency := Currency(strings.ToUpper(code))
+	if _, ok := Currencies[currency]; !ok {
+		return "", UnknownCurrencyError.WithDetail(code)
+	}
+	return currency, nil
+}
+
+// How many digits come after the decimal point. Currencies missing from Currencies, and no
+// currency at all, are taken to have 2.
+func (c Currency) MinorUnits() int {
+	if info, ok := Currencies[c]; ok {
+		return info.MinorUnits
+	}
+	return 2
+}
+
+// The currency's symbol, or its code if it doesn't have one.
+func (c Currency) Symbol() string {
+	if info, ok := Currencies[c]; ok && info.Symbol != "" {
+		return info.Symbol
+	}
+	return string(c)
+}
+
+// How many minor units make up one major unit, such as 100 cents to the dollar.
+func (c Currency) minorPerMajor() int64 {
+	n := int64(1)
+	for i := 0; i < c.MinorUnits(); i++ {
+		n *= 10
+	}
+	return n
+}
+
+// Create a new amount in the default currency from a combination of dollars and cents.
 // For negative amounts, make sure to use the negative sign on both the dollars and cents value
 // (otherwise you may get unintuitive behavior, for example, `NewAmount(-1, 15) == NewAmount(0, -85)`).
-func NewAmount(dollars, cents int) Amount {
-	return Amount{
-		cents: dollars*CentsPerDollar + cents,
+func NewAmount(dollars, cents int64) Amount {
+	return MajorAmount(DefaultCurrency, dollars).Add(MinorAmount(DefaultCurrency, cents))
+}
+
+func Cents(cents int64) Amount {
+	return MinorAmount(DefaultCurrency, cents)
+}
+
+func Dollars(dollars int64) Amount {
+	return MajorAmount(DefaultCurrency, dollars)
+}
+
+// An amount of the currency's smallest unit, such as cents, yen or fils. Panics with an
+// AmountRangeError if the amount is beyond MaxMinorUnits.
+func MinorAmount(currency Currency, units int64) Amount {
+	if !inRange(units) {
+		panic(amountRangeError(fmt.Sprintf("(%d minor units)", units)))
+	}
+	return Amount{minor: units, currency: currency}
+}
+
+// An amount of whole units of the currency, such as dollars, yen or dinars. Panics with an
+// AmountRangeError if the amount is beyond MaxMinorUnits.
+func MajorAmount(currency Currency, units int64) Amount {
+	amount, err := MajorAmountChecked(currency, units)
+	if err != nil {
+		panic(err)
 	}
+	return amount
 }
 
-func Cents(cents int) Amount {
-	return NewAmount(0, cents)
+// MajorAmount, returning an AmountRangeError rather than panicking if the amount doesn't fit.
+func MajorAmountChecked(currency Currency, units int64) (Amount, error) {
+	minor, ok := mulMinor(units, currency.minorPerMajor())
+	if !ok {
+		return ZeroAmount, amountRangeError(fmt.Sprintf("(%d %s)", units, currency))
+	}
+	return Amount{minor: minor, currency: currency}, nil
 }
 
-func Dollars(dollars int) Amount {
-	return NewAmount(dollars, 0)
+// Zero in the given currency, such as the opening balance of an empty account.
+func Zero(currency Currency) Amount {
+	return Amount{currency: currency}
 }
 
+// Parse an amount in the default currency, such as "40" or "12.50".
 func ParseAmount(amount string) (Amount, error) {
+	return ParseAmountIn(amount, DefaultCurrency)
+}
+
+// Parse an amount in the given currency. There can be no more digits after the decimal point than
+// the currency has minor units, so "12.50" is fine in dollars but not in yen. Amounts beyond
+// MaxMinorUnits fail with an AmountRangeError.
+func ParseAmountIn(amount string, currency Currency) (Amount, error) {
+	original := amount
 	negative := false
 	if strings.HasPrefix(amount, "-") {
 		negative = true
@@ -41,25 +196,36 @@ func ParseAmount(amount string) (Amount, error) {
 	}
 
 	parts := strings.Split(amount, ".")
+	if len(parts) > 2 {
+		return ZeroAmount, amountParseError("too many decimal points", nil)
+	}
 	if parts[0] == "" {
 		parts[0] = "0"
 	}
-	dollars, err := strconv.Atoi(parts[0])
+	dollars, err := strconv.ParseInt(parts[0], 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return ZeroAmount, amountRangeError(original)
+	} else if err != nil {
+		return ZeroAmount, amountParseError("error parsing dollars", err)
+	}
+	amt, err := MajorAmountChecked(currency, dollars)
 	if err != nil {
-		return ZeroAmount, AmountParseError("error parsing dollars: " + err.Error())
-	}
-	amt := Dollars(dollars)
-	if len(parts) > 1 {
-		if len(parts[1]) > 2 {
-			return ZeroAmount, AmountParseError("too many digits in the cents")
-		} else if len(parts[1]) <= 1 {
-			parts[1] = parts[1] + "0"
+		return ZeroAmount, amountRangeError(original)
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		digits := currency.MinorUnits()
+		if len(parts[1]) > digits {
+			return ZeroAmount, amountParseError("too many digits in the cents", nil)
+		}
+		parts[1] += strings.Repeat("0", digits-len(parts[1]))
+		cents, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || strings.TrimLeft(parts[1], "0123456789") != "" {
+			return ZeroAmount, amountParseError("error parsing cents", err)
 		}
-		cents, err := strconv.Atoi(parts[1])
+		amt, err = amt.AddChecked(Amount{minor: cents, currency: currency})
 		if err != nil {
-			return ZeroAmount, AmountParseError("error parsing cents: " + err.Error())
+			return ZeroAmount, amountRangeError(original)
 		}
-		amt = amt.Add(Cents(cents))
 	}
 	if negative {
 		return amt.Negative(), nil
@@ -67,42 +233,152 @@ func ParseAmount(amount string) (Amount, error) {
 	return amt, nil
 }
 
+// A sum of money in a single currency, counted in the currency's minor units. Amounts in different
+// currencies can't be added, subtracted or compared; doing so panics with a CurrencyMismatchError,
+// so check amounts that come from outside with SameCurrency, or use AddChecked and SubtractChecked.
+// ZeroAmount has no currency, and goes with any.
+//
+// Amounts never hold more than MaxMinorUnits either way. Arithmetic that would go beyond that
+// panics with an AmountRangeError rather than wrapping; the checked versions return it instead.
 type Amount struct {
-	cents int
+	minor    int64
+	currency Currency
+}
+
+// The amount's currency, or "" for ZeroAmount.
+func (a Amount) Currency() Currency {
+	return a.currency
+}
+
+// The amount in the currency's minor units, such as cents.
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+func (a Amount) IsZero() bool {
+	return a.minor == 0
+}
+
+// Whether the amounts can be added or compared: they're in the same currency, or one has none.
+func (a Amount) SameCurrency(other Amount) bool {
+	return a.currency == other.currency || a.currency == "" || other.currency == ""
+}
+
+// The currency of the result of combining the amounts, panicking if they can't be combined.
+func (a Amount) combined(other Amount) Currency {
+	currency, err := a.checkCurrency(other)
+	if err != nil {
+		panic(err)
+	}
+	return currency
+}
+
+func (a Amount) checkCurrency(other Amount) (Currency, error) {
+	if !a.SameCurrency(other) {
+		return "", currencyMismatchError(a.currency, other.currency)
+	}
+	if a.currency == "" {
+		return other.currency, nil
+	}
+	return a.currency, nil
 }
 
+// The amount written with as many digits after the decimal point as its currency has minor units,
+// such as "-1234.50", "1234" for yen or "1.234" for dinars. The currency itself isn't written.
 func (a Amount) String() string {
-	dollars := a.cents / CentsPerDollar
-	cents := a.cents % CentsPerDollar
-	if cents < 0 {
-		cents = cents * -1
+	sign := ""
+	if a.minor < 0 {
+		sign = "-"
 	}
-	centsStr := strconv.Itoa(cents)
-	if cents < 10 {
-		centsStr = "0" + centsStr
+	digits := a.currency.MinorUnits()
+	perMajor := a.currency.minorPerMajor()
+	major := a.minor / perMajor
+	minor := a.minor % perMajor
+	if major < 0 {
+		major = major * -1
 	}
-	return fmt.Sprintf("%d.%s", dollars, centsStr)
+	if minor < 0 {
+		minor = minor * -1
+	}
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, major)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, major, digits, minor)
 }
 
 func (a Amount) Add(amount Amount) Amount {
-	return Amount{
-		cents: a.cents + amount.cents,
+	sum, err := a.AddChecked(amount)
+	if err != nil {
+		panic(err)
 	}
+	return sum
 }
 
 func (a Amount) Subtract(amount Amount) Amount {
-	return Amount{
-		cents: a.cents - amount.cents,
+	difference, err := a.SubtractChecked(amount)
+	if err != nil {
+		panic(err)
+	}
+	return difference
+}
+
+// Add, returning a CurrencyMismatchError if the currencies differ or an AmountRangeError if the
+// sum is out of range, rather than panicking.
+func (a Amount) AddChecked(amount Amount) (Amount, error) {
+	currency, err := a.checkCurrency(amount)
+	if err != nil {
+		return ZeroAmount, err
+	}
+	minor, ok := addMinor(a.minor, amount.minor)
+	if !ok {
+		return ZeroAmount, amountRangeError(fmt.Sprintf("(%s + %s)", a, amount))
+	}
+	return Amount{minor: minor, currency: currency}, nil
+}
+
+// Subtract, returning a CurrencyMismatchError if the currencies differ or an AmountRangeError if
+// the difference is out of range, rather than panicking.
+func (a Amount) SubtractChecked(amount Amount) (Amount, error) {
+	currency, err := a.checkCurrency(amount)
+	if err != nil {
+		return ZeroAmount, err
+	}
+	minor, ok := addMinor(a.minor, -amount.minor)
+	if !ok {
+		return ZeroAmount, amountRangeError(fmt.Sprintf("(%s - %s)", a, amount))
+	}
+	return Amount{minor: minor, currency: currency}, nil
+}
+
+// The amount multiplied by a whole number, such as the value of a stack of notes. Panics with an
+// AmountRangeError if the product is out of range.
+func (a Amount) Times(n int) Amount {
+	product, err := a.TimesChecked(n)
+	if err != nil {
+		panic(err)
+	}
+	return product
+}
+
+// Times, returning an AmountRangeError rather than panicking if the product is out of range.
+func (a Amount) TimesChecked(n int) (Amount, error) {
+	minor, ok := mulMinor(a.minor, int64(n))
+	if !ok {
+		return ZeroAmount, amountRangeError(fmt.Sprintf("(%s * %d)", a, n))
 	}
+	return Amount{minor: minor, currency: a.currency}, nil
 }
 
 func (a Amount) GreaterThan(other Amount) bool {
-	return a.cents > other.cents
+	a.combined(other)
+	return a.minor > other.minor
 }
 
+// The amount with its sign flipped. The supported range is symmetric, so this can't overflow.
 func (a Amount) Negative() Amount {
 	return Amount{
-		cents: a.cents * -1,
+		minor:    a.minor * -1,
+		currency: a.currency,
 	}
 }
 
@@ -115,5 +391,6 @@ func (a Amount) Abs() Amount {
 }
 
 func (a Amount) MultipleOf(other Amount) bool {
-	return a.cents%other.cents == 0
+	a.combined(other)
+	return a.minor%other.minor == 0
 }
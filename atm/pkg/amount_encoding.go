@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var (
+	_ json.Marshaler           = Amount{}
+	_ json.Unmarshaler         = new(Amount)
+	_ encoding.TextMarshaler   = Amount{}
+	_ encoding.TextUnmarshaler = new(Amount)
+	_ driver.Valuer            = Amount{}
+	_ sql.Scanner              = new(Amount)
+)
+
+// How an amount is written in JSON: the amount as a decimal string, so it never passes through a
+// float, and its currency code. ZeroAmount has no currency, so leaves it out.
+type amountJSON struct {
+	Amount   string   `json:"amount"`
+	Currency Currency `json:"currency,omitempty"`
+}
+
+// Write the amount as JSON, such as {"amount": "12.50", "currency": "USD"}.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(amountJSON{Amount: a.String(), Currency: a.currency})
+}
+
+// Read an amount written by MarshalJSON. A missing currency is DefaultCurrency, except for zero,
+// which comes back as ZeroAmount. Like the standard types, null leaves the amount as it was.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var record amountJSON
+	if err := json.Unmarshal(data, &record); err != nil {
+		return amountParseError("expected an object with an amount and a currency", err)
+	}
+	amount, err := decodeAmount(record.Amount, string(record.Currency))
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Write the amount as its currency code and decimal, such as "USD 12.50", or just "0.00" for
+// ZeroAmount.
+func (a Amount) MarshalText() ([]byte, error) {
+	if a.currency == "" {
+		return []byte(a.String()), nil
+	}
+	return []byte(string(a.currency) + " " + a.String()), nil
+}
+
+// Read an amount written by MarshalText. Like UnmarshalJSON, an amount without a currency code is
+// in DefaultCurrency, or ZeroAmount if it's zero.
+func (a *Amount) UnmarshalText(text []byte) error {
+	amount, err := parseAmountText(string(text))
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Store the amount in a database column as text, in the same form as MarshalText, so the currency
+// is kept with it and nothing is lost to floating point.
+func (a Amount) Value() (driver.Value, error) {
+	text, err := a.MarshalText()
+	return string(text), err
+}
+
+// Read an amount from a database column. Text is read as by UnmarshalText, whole numbers as minor
+// units of DefaultCurrency, and NULL as ZeroAmount.
+func (a *Amount) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*a = ZeroAmount
+		return nil
+	case string:
+		return a.UnmarshalText([]byte(value))
+	case []byte:
+		return a.UnmarshalText(value)
+	case int64:
+		if value == 0 {
+			*a = ZeroAmount
+		} else if !inRange(value) {
+			return amountRangeError(fmt.Sprintf("(%d minor units)", value))
+		} else {
+			*a = Amount{minor: value, currency: DefaultCurrency}
+		}
+		return nil
+	default:
+		return amountParseError(fmt.Sprintf("can't scan %T into an amount", src), nil)
+	}
+}
+
+func parseAmountText(text string) (Amount, error) {
+	fields := strings.Fields(text)
+	switch len(fields) {
+	case 1:
+		return decodeAmount(fields[0], "")
+	case 2:
+		return decodeAmount(fields[1], fields[0])
+	default:
+		return ZeroAmount, amountParseError(fmt.Sprintf("expected a currency and an amount, got %q", text), nil)
+	}
+}
+
+// An amount from its decimal and currency code. A missing code is DefaultCurrency, and zero
+// without one is ZeroAmount.
+func decodeAmount(amount, code string) (Amount, error) {
+	if amount == "" {
+		return ZeroAmount, amountParseError("missing amount", nil)
+	}
+	currency := DefaultCurrency
+	if code != "" {
+		var err error
+		if currency, err = ParseCurrency(code); err != nil {
+			return ZeroAmount, err
+		}
+	}
+	parsed, err := ParseAmountIn(amount, currency)
+	if err != nil {
+		return ZeroAmount, err
+	}
+	if code == "" && parsed.IsZero() {
+		return ZeroAmount, nil
+	}
+	return parsed, nil
+}
@@ -0,0 +1,115 @@
+package pkg_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Amount encoding", func() {
+	amounts := []pkg.Amount{
+		pkg.NewAmount(12, 50),
+		pkg.Cents(-5),
+		pkg.Zero(pkg.EUR),
+		pkg.MajorAmount(pkg.JPY, 1851),
+		pkg.MinorAmount(pkg.KWD, 1234),
+		pkg.ZeroAmount,
+	}
+
+	Context("JSON", func() {
+		It("writes decimal strings with the currency", func() {
+			Expect(json.Marshal(pkg.NewAmount(12, 50))).To(MatchJSON(`{"amount": "12.50", "currency": "USD"}`))
+			Expect(json.Marshal(pkg.MajorAmount(pkg.JPY, 1851))).To(MatchJSON(`{"amount": "1851", "currency": "JPY"}`))
+			Expect(json.Marshal(pkg.ZeroAmount)).To(MatchJSON(`{"amount": "0.00"}`))
+		})
+
+		It("round-trips", func() {
+			for _, amount := range amounts {
+				data, err := json.Marshal(amount)
+				Expect(err).To(BeNil())
+				var decoded pkg.Amount
+				Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+				Expect(decoded).To(Equal(amount))
+			}
+		})
+
+		It("reads amounts without a currency in the default one", func() {
+			var decoded pkg.Amount
+			Expect(json.Unmarshal([]byte(`{"amount": "40"}`), &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(pkg.Dollars(40)))
+		})
+
+		It("leaves amounts alone for null", func() {
+			decoded := struct{ Balance pkg.Amount }{Balance: pkg.Dollars(40)}
+			Expect(json.Unmarshal([]byte(`{"Balance": null}`), &decoded)).To(Succeed())
+			Expect(decoded.Balance).To(Equal(pkg.Dollars(40)))
+		})
+
+		It("refuses amounts it can't read", func() {
+			var decoded pkg.Amount
+			Expect(json.Unmarshal([]byte(`12.50`), &decoded)).To(MatchError(pkg.AmountParseError))
+			Expect(json.Unmarshal([]byte(`{"currency": "USD"}`), &decoded)).To(MatchError(pkg.AmountParseError))
+			Expect(json.Unmarshal([]byte(`{"amount": "1.5", "currency": "JPY"}`), &decoded)).To(MatchError(pkg.AmountParseError))
+			Expect(json.Unmarshal([]byte(`{"amount": "1", "currency": "XYZ"}`), &decoded)).To(MatchError(pkg.UnknownCurrencyError))
+		})
+	})
+
+	Context("text", func() {
+		It("writes the currency code before the amount", func() {
+			Expect(pkg.NewAmount(-12, -50).MarshalText()).To(Equal([]byte("USD -12.50")))
+			Expect(pkg.ZeroAmount.MarshalText()).To(Equal([]byte("0.00")))
+		})
+
+		It("round-trips", func() {
+			for _, amount := range amounts {
+				text, err := amount.MarshalText()
+				Expect(err).To(BeNil())
+				var decoded pkg.Amount
+				Expect(decoded.UnmarshalText(text)).To(Succeed())
+				Expect(decoded).To(Equal(amount))
+			}
+		})
+
+		It("works as a map key", func() {
+			data, err := json.Marshal(map[pkg.Amount]int{pkg.Dollars(20): 3})
+			Expect(err).To(BeNil())
+			Expect(data).To(MatchJSON(`{"USD 20.00": 3}`))
+			var decoded map[pkg.Amount]int
+			Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(map[pkg.Amount]int{pkg.Dollars(20): 3}))
+		})
+
+		It("refuses text it can't read", func() {
+			var decoded pkg.Amount
+			for _, bad := range []string{"", "USD 1 2", "USD abc", "XYZ 1.00"} {
+				Expect(decoded.UnmarshalText([]byte(bad))).NotTo(Succeed(), bad)
+			}
+		})
+	})
+
+	Context("SQL", func() {
+		It("stores amounts as text", func() {
+			Expect(pkg.MinorAmount(pkg.KWD, 1234).Value()).To(Equal("KWD 1.234"))
+		})
+
+		It("scans text, minor units and NULL", func() {
+			var scanned pkg.Amount
+			Expect(scanned.Scan("EUR 100.00")).To(Succeed())
+			Expect(scanned).To(Equal(pkg.MajorAmount(pkg.EUR, 100)))
+			Expect(scanned.Scan([]byte("JPY 5"))).To(Succeed())
+			Expect(scanned).To(Equal(pkg.MajorAmount(pkg.JPY, 5)))
+			Expect(scanned.Scan(int64(1250))).To(Succeed())
+			Expect(scanned).To(Equal(pkg.NewAmount(12, 50)))
+			Expect(scanned.Scan(nil)).To(Succeed())
+			Expect(scanned).To(Equal(pkg.ZeroAmount))
+		})
+
+		It("refuses values it can't scan", func() {
+			var scanned pkg.Amount
+			Expect(scanned.Scan(1.5)).To(MatchError(pkg.AmountParseError))
+			Expect(scanned.Scan(pkg.MaxMinorUnits + 1)).To(MatchError(pkg.AmountRangeError))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"strings"
+)
+
+type NegativeStyle string
+
+const (
+	// Negative amounts are written with a leading minus sign, as in "-$1,234.50".
+	NegativeSign NegativeStyle = "sign"
+	// Negative amounts are written in parentheses, as accountants do, as in "($1,234.50)".
+	NegativeParentheses NegativeStyle = "parentheses"
+)
+
+var (
+	// How Amount.String writes amounts, such as "-1234.50".
+	PlainAmountFormat = AmountFormat{DecimalSeparator: "."}
+)
+
+// How to write amounts: the currency symbol and where it goes, the separators, and how to show
+// negatives. The zero value writes amounts like Amount.String.
+type AmountFormat struct {
+	// Written before the amount, or after it if SymbolAfter is set. Empty for no symbol.
+	Symbol string
+	// Write the symbol of the amount's own currency rather than Symbol, so "€12.50" and "$12.50"
+	// can share a format. ZeroAmount gets the symbol of DefaultCurrency.
+	CurrencySymbol bool
+	SymbolAfter    bool
+	// Put a space between the symbol and the digits, as in "1.234,50 €".
+	SymbolSpace bool
+	// Defaults to ".".
+	DecimalSeparator string
+	// Written between each group of three digits in the whole part. Empty for no grouping.
+	GroupSeparator string
+	// Defaults to NegativeSign.
+	Negative NegativeStyle
+}
+
+// The amount written in the given format, such as "$1,234.50", "1.234,50 €" or "($20.00)".
+// Currencies without minor units, such as yen, are written without a decimal separator.
+func (a Amount) Format(format AmountFormat) string {
+	s := a.Abs().String()
+	whole, fraction := s, ""
+	if point := strings.Index(s, "."); point >= 0 {
+		whole, fraction = s[:point], s[point+1:]
+	}
+	if format.GroupSeparator != "" {
+		whole = groupDigits(whole, format.GroupSeparator)
+	}
+	decimal := format.DecimalSeparator
+	if decimal == "" {
+		decimal = "."
+	}
+	s = whole
+	if fraction != "" {
+		s += decimal + fraction
+	}
+
+	symbol := format.Symbol
+	if format.CurrencySymbol {
+		currency := a.currency
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+		symbol = currency.Symbol()
+	}
+	if symbol != "" {
+		space := ""
+		if format.SymbolSpace {
+			space = " "
+		}
+		if format.SymbolAfter {
+			s = s + space + symbol
+		} else {
+			s = symbol + space + s
+		}
+	}
+
+	if ZeroAmount.GreaterThan(a) {
+		if format.Negative == NegativeParentheses {
+			return "(" + s + ")"
+		}
+		return "-" + s
+	}
+	return s
+}
+
+// "1234567" as "1,234,567", with the given separator.
+func groupDigits(digits, separator string) string {
+	var groups []string
+	for len(digits) > 3 {
+		groups = append([]string{digits[len(digits)-3:]}, groups...)
+		digits = digits[:len(digits)-3]
+	}
+	return strings.Join(append([]string{digits}, groups...), separator)
+}
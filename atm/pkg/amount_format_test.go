@@ -0,0 +1,64 @@
+package pkg_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Amount formatting", func() {
+	var (
+		us = pkg.AmountFormat{
+			Symbol:           "$",
+			DecimalSeparator: ".",
+			GroupSeparator:   ",",
+		}
+		euro = pkg.AmountFormat{
+			Symbol:           "€",
+			SymbolAfter:      true,
+			SymbolSpace:      true,
+			DecimalSeparator: ",",
+			GroupSeparator:   ".",
+		}
+	)
+
+	It("writes plain amounts like String", func() {
+		for _, amount := range []pkg.Amount{pkg.ZeroAmount, pkg.Cents(5), pkg.Cents(-5), pkg.NewAmount(1234567, 50), pkg.NewAmount(-1234, -5)} {
+			Expect(amount.Format(pkg.PlainAmountFormat)).To(Equal(amount.String()))
+			Expect(amount.Format(pkg.AmountFormat{})).To(Equal(amount.String()))
+		}
+	})
+
+	It("groups digits", func() {
+		Expect(pkg.NewAmount(1234567, 50).Format(us)).To(Equal("$1,234,567.50"))
+		Expect(pkg.NewAmount(123456, 0).Format(us)).To(Equal("$123,456.00"))
+		Expect(pkg.Dollars(999).Format(us)).To(Equal("$999.00"))
+		Expect(pkg.Dollars(1000).Format(us)).To(Equal("$1,000.00"))
+		Expect(pkg.Cents(7).Format(us)).To(Equal("$0.07"))
+	})
+
+	It("follows the locale's conventions", func() {
+		Expect(pkg.NewAmount(1234, 50).Format(euro)).To(Equal("1.234,50 €"))
+		Expect(pkg.NewAmount(-1234, -50).Format(euro)).To(Equal("-1.234,50 €"))
+	})
+
+	It("writes negatives in parentheses", func() {
+		us.Negative = pkg.NegativeParentheses
+		euro.Negative = pkg.NegativeParentheses
+		Expect(pkg.NewAmount(-1234, -50).Format(us)).To(Equal("($1,234.50)"))
+		Expect(pkg.NewAmount(-1234, -50).Format(euro)).To(Equal("(1.234,50 €)"))
+		Expect(pkg.NewAmount(1234, 50).Format(us)).To(Equal("$1,234.50"))
+	})
+
+	It("writes negatives with a sign", func() {
+		us.Negative = pkg.NegativeSign
+		Expect(pkg.Cents(-5).Format(us)).To(Equal("-$0.05"))
+	})
+
+	It("writes the amount's own currency symbol", func() {
+		format := pkg.AmountFormat{CurrencySymbol: true, GroupSeparator: ","}
+		Expect(pkg.MajorAmount(pkg.EUR, 1234).Format(format)).To(Equal("€1,234.00"))
+		Expect(pkg.MajorAmount(pkg.JPY, 1234).Format(format)).To(Equal("¥1,234"))
+		Expect(pkg.ZeroAmount.Format(format)).To(Equal("$0.00"))
+	})
+})
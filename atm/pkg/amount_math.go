@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Factors and exchange rates are kept to this many decimal places.
+const RateDecimals = 8
+
+const rateScale = 100000000
+
+var (
+	FactorParseError = NewATMError("factor_parse_error", InputCategory, "Error parsing rate.")
+)
+
+// A decimal to multiply amounts by, such as an interest rate of 0.0125 or a fee of 2.5 percent,
+// kept to RateDecimals places.
+type Factor struct {
+	// The factor in units of 10^-RateDecimals.
+	scaled int64
+}
+
+// Parse a factor written as a decimal, such as "0.0125" or "-1.5", with at most RateDecimals
+// digits after the decimal point.
+func ParseFactor(factor string) (Factor, error) {
+	scaled, ok := parseScaled(factor)
+	if !ok {
+		return Factor{}, FactorParseError.WithDetail(factor)
+	}
+	return Factor{scaled: scaled}, nil
+}
+
+// The factor written as a decimal without trailing zeros, such as "0.0125".
+func (f Factor) String() string {
+	return formatScaled(f.scaled)
+}
+
+// A decimal as a whole number of 10^-RateDecimals, or false if it isn't one or is too large.
+func parseScaled(s string) (int64, bool) {
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	parts := strings.Split(digits, ".")
+	if len(parts) > 2 || parts[0]+strings.Join(parts[1:], "") == "" {
+		return 0, false
+	}
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > RateDecimals {
+		return 0, false
+	}
+	digits = parts[0] + fraction + strings.Repeat("0", RateDecimals-len(fraction))
+	if strings.TrimLeft(digits, "0123456789") != "" {
+		return 0, false
+	}
+	scaled, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if negative {
+		scaled = -scaled
+	}
+	return scaled, true
+}
+
+func formatScaled(scaled int64) string {
+	sign := ""
+	if scaled < 0 {
+		sign = "-"
+		scaled = -scaled
+	}
+	s := fmt.Sprintf("%s%d.%0*d", sign, scaled/rateScale, RateDecimals, scaled%rateScale)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// The amount times the factor, rounded to the currency's minor units as given, such as a month's
+// interest on a balance. Panics with an AmountRangeError if the result is out of range.
+func (a Amount) MulRate(rate Factor, rounding RoundingMode) Amount {
+	product, err := a.MulRateChecked(rate, rounding)
+	if err != nil {
+		panic(err)
+	}
+	return product
+}
+
+// MulRate, returning an AmountRangeError rather than panicking if the result is out of range.
+func (a Amount) MulRateChecked(rate Factor, rounding RoundingMode) (Amount, error) {
+	return a.scale(rate.scaled, rateScale, rounding)
+}
+
+// The given percentage of the amount, such as a 2.5 percent fee, rounded as given. Panics with an
+// AmountRangeError if the result is out of range.
+func (a Amount) Percent(percent Factor, rounding RoundingMode) Amount {
+	share, err := a.PercentChecked(percent, rounding)
+	if err != nil {
+		panic(err)
+	}
+	return share
+}
+
+// Percent, returning an AmountRangeError rather than panicking if the result is out of range.
+func (a Amount) PercentChecked(percent Factor, rounding RoundingMode) (Amount, error) {
+	return a.scale(percent.scaled, rateScale*100, rounding)
+}
+
+// The amount times numerator/denominator, rounded to minor units.
+func (a Amount) scale(numerator, denominator int64, rounding RoundingMode) (Amount, error) {
+	n := new(big.Int).Mul(big.NewInt(a.minor), big.NewInt(numerator))
+	result := rounding.divide(n, big.NewInt(denominator))
+	if result.CmpAbs(big.NewInt(MaxMinorUnits)) > 0 {
+		return ZeroAmount, amountRangeError(fmt.Sprintf("(%s * %d/%d)", a, numerator, denominator))
+	}
+	return Amount{minor: result.Int64(), currency: a.currency}, nil
+}
+
+// Split the amount into shares in proportion to the ratios, such as Allocate(1, 1, 1) to split a
+// fee three ways. The shares always add up to exactly the amount: the minor units left over from
+// rounding each share down go one at a time to the shares that lost the most, the earliest first
+// when they lost the same. Panics if a ratio is negative or none is positive.
+func (a Amount) Allocate(ratios ...int) []Amount {
+	total := int64(0)
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			panic(fmt.Sprintf("Allocate: negative ratio %d", ratio))
+		}
+		total += int64(ratio)
+	}
+	if total == 0 {
+		panic("Allocate: no positive ratio")
+	}
+
+	whole := a.Abs().minor
+	shares := make([]int64, len(ratios))
+	remainders := make([]*big.Int, len(ratios))
+	leftover := whole
+	for i, ratio := range ratios {
+		n := new(big.Int).Mul(big.NewInt(whole), big.NewInt(int64(ratio)))
+		q, r := n.QuoRem(n, big.NewInt(total), new(big.Int))
+		shares[i] = q.Int64()
+		remainders[i] = r
+		leftover -= shares[i]
+	}
+	order := make([]int, len(ratios))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]].Cmp(remainders[order[j]]) > 0
+	})
+	for i := int64(0); i < leftover; i++ {
+		shares[order[i]]++
+	}
+
+	result := make([]Amount, len(shares))
+	for i, share := range shares {
+		result[i] = Amount{minor: share, currency: a.currency}
+		if a.minor < 0 {
+			result[i] = result[i].Negative()
+		}
+	}
+	return result
+}
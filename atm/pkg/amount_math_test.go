@@ -0,0 +1,132 @@
+package pkg_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Amount math", func() {
+
+	mustParseFactor := func(factor string) pkg.Factor {
+		parsed, err := pkg.ParseFactor(factor)
+		Expect(err).To(BeNil())
+		return parsed
+	}
+
+	Context("factors", func() {
+		It("parses and writes decimals", func() {
+			Expect(mustParseFactor("0.0125").String()).To(Equal("0.0125"))
+			Expect(mustParseFactor("-1.50").String()).To(Equal("-1.5"))
+			Expect(mustParseFactor("0").String()).To(Equal("0"))
+			for _, bad := range []string{"", "-", ".", "1.2.3", "1.123456789", "abc", "--1"} {
+				_, err := pkg.ParseFactor(bad)
+				Expect(err).To(MatchError(pkg.FactorParseError), bad)
+			}
+		})
+	})
+
+	Context("rounding", func() {
+		It("rounds each mode its own way", func() {
+			half := mustParseFactor("0.5")
+			cases := []struct {
+				cents                         int64
+				halfUp, halfEven, floor, ceil int64
+			}{
+				{5, 3, 2, 2, 3},
+				{7, 4, 4, 3, 4},
+				{-5, -3, -2, -3, -2},
+				{-7, -4, -4, -4, -3},
+				{4, 2, 2, 2, 2},
+			}
+			for _, c := range cases {
+				amount := pkg.Cents(c.cents)
+				Expect(amount.MulRate(half, pkg.RoundHalfUp)).To(Equal(pkg.Cents(c.halfUp)), "%d half up", c.cents)
+				Expect(amount.MulRate(half, pkg.RoundHalfEven)).To(Equal(pkg.Cents(c.halfEven)), "%d half even", c.cents)
+				Expect(amount.MulRate(half, pkg.RoundFloor)).To(Equal(pkg.Cents(c.floor)), "%d floor", c.cents)
+				Expect(amount.MulRate(half, pkg.RoundCeiling)).To(Equal(pkg.Cents(c.ceil)), "%d ceiling", c.cents)
+			}
+		})
+
+		It("multiplies by rates and takes percentages", func() {
+			Expect(pkg.Dollars(1000).MulRate(mustParseFactor("0.0125"), pkg.RoundHalfEven)).To(Equal(pkg.NewAmount(12, 50)))
+			Expect(pkg.NewAmount(19, 99).Percent(mustParseFactor("2.5"), pkg.RoundHalfUp)).To(Equal(pkg.Cents(50)))
+			Expect(pkg.NewAmount(19, 99).Percent(mustParseFactor("2.5"), pkg.RoundFloor)).To(Equal(pkg.Cents(49)))
+			Expect(pkg.MajorAmount(pkg.JPY, 999).Percent(mustParseFactor("10"), pkg.RoundHalfUp)).To(Equal(pkg.MajorAmount(pkg.JPY, 100)))
+		})
+
+		It("refuses results out of range", func() {
+			huge := pkg.MinorAmount(pkg.USD, pkg.MaxMinorUnits)
+			_, err := huge.MulRateChecked(mustParseFactor("2"), pkg.RoundHalfUp)
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			_, err = huge.PercentChecked(mustParseFactor("200"), pkg.RoundHalfUp)
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			Expect(func() { huge.Percent(mustParseFactor("200"), pkg.RoundHalfUp) }).To(Panic())
+			Expect(huge.MulRate(mustParseFactor("-1"), pkg.RoundHalfUp)).To(Equal(huge.Negative()))
+		})
+	})
+
+	Context("allocation", func() {
+		It("hands out leftover minor units to the shares that lost the most", func() {
+			Expect(pkg.Dollars(100).Allocate(1, 1, 1)).To(Equal([]pkg.Amount{
+				pkg.Cents(3334), pkg.Cents(3333), pkg.Cents(3333),
+			}))
+			Expect(pkg.Cents(5).Allocate(3, 7)).To(Equal([]pkg.Amount{pkg.Cents(2), pkg.Cents(3)}))
+			Expect(pkg.Cents(100).Allocate(1, 0, 1)).To(Equal([]pkg.Amount{
+				pkg.Cents(50), pkg.Zero(pkg.USD), pkg.Cents(50),
+			}))
+		})
+
+		It("always adds up to the amount", func() {
+			for _, amount := range []pkg.Amount{pkg.Cents(1), pkg.Cents(-101), pkg.NewAmount(1234, 57), pkg.MajorAmount(pkg.JPY, 7)} {
+				total := pkg.Zero(amount.Currency())
+				for _, share := range amount.Allocate(2, 3, 5, 7) {
+					total = total.Add(share)
+				}
+				Expect(total).To(Equal(amount))
+			}
+		})
+
+		It("splits negative amounts like positive ones", func() {
+			Expect(pkg.Cents(-100).Allocate(1, 1, 1)).To(Equal([]pkg.Amount{
+				pkg.Cents(-34), pkg.Cents(-33), pkg.Cents(-33),
+			}))
+		})
+
+		It("panics on ratios it can't split by", func() {
+			Expect(func() { pkg.Dollars(1).Allocate(1, -1) }).To(Panic())
+			Expect(func() { pkg.Dollars(1).Allocate(0, 0) }).To(Panic())
+			Expect(func() { pkg.Dollars(1).Allocate() }).To(Panic())
+		})
+	})
+
+	Context("precise amounts", func() {
+		It("accrues fractions of a minor unit and carries what's left", func() {
+			daily := mustParseFactor("0.0001")
+			accrued := pkg.PreciseAmount{}
+			for day := 0; day < 30; day++ {
+				accrued = accrued.Add(pkg.NewAmount(123, 45).MulRatePrecise(daily))
+			}
+			Expect(accrued.String()).To(Equal("0.37035"))
+			posted, rest := accrued.Split(pkg.RoundFloor)
+			Expect(posted).To(Equal(pkg.Cents(37)))
+			Expect(rest.String()).To(Equal("0.00035"))
+			Expect(rest.Currency()).To(Equal(pkg.USD))
+			Expect(accrued.Round(pkg.RoundCeiling)).To(Equal(pkg.Cents(38)))
+		})
+
+		It("holds amounts exactly", func() {
+			Expect(pkg.NewAmount(12, 50).Precise().String()).To(Equal("12.50"))
+			Expect(pkg.MajorAmount(pkg.JPY, -5).Precise().String()).To(Equal("-5"))
+			Expect(pkg.NewAmount(12, 50).Precise().Round(pkg.RoundHalfEven)).To(Equal(pkg.NewAmount(12, 50)))
+			Expect(pkg.PreciseAmount{}.IsZero()).To(BeTrue())
+			Expect(pkg.PreciseAmount{}.String()).To(Equal("0.00"))
+		})
+
+		It("refuses to mix currencies", func() {
+			Expect(func() {
+				pkg.Dollars(1).Precise().Add(pkg.MajorAmount(pkg.EUR, 1).Precise())
+			}).To(Panic())
+		})
+	})
+})
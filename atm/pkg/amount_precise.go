@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// An amount kept to RateDecimals places beyond the currency's minor units, for sums that build up
+// a fraction of a cent at a time, such as daily interest. Accrue into one with Add, then post
+// whole minor units with Round or Split. The zero value is zero in no currency in particular, like
+// ZeroAmount.
+type PreciseAmount struct {
+	// The amount in units of 10^-RateDecimals minor units. Never modified once set, so copies can
+	// share it; nil is zero.
+	units    *big.Int
+	currency Currency
+}
+
+// The amount, exactly, with room for fractions of a minor unit.
+func (a Amount) Precise() PreciseAmount {
+	units := big.NewInt(a.minor)
+	return PreciseAmount{units: units.Mul(units, big.NewInt(rateScale)), currency: a.currency}
+}
+
+// The amount times the factor without rounding, such as a day's interest to accrue.
+func (a Amount) MulRatePrecise(rate Factor) PreciseAmount {
+	units := big.NewInt(a.minor)
+	return PreciseAmount{units: units.Mul(units, big.NewInt(rate.scaled)), currency: a.currency}
+}
+
+func (p PreciseAmount) Currency() Currency {
+	return p.currency
+}
+
+func (p PreciseAmount) IsZero() bool {
+	return p.value().Sign() == 0
+}
+
+func (p PreciseAmount) value() *big.Int {
+	if p.units == nil {
+		return new(big.Int)
+	}
+	return p.units
+}
+
+// The sum of the amounts. Like Amount's Add, panics with a CurrencyMismatchError if they're in
+// different currencies.
+func (p PreciseAmount) Add(other PreciseAmount) PreciseAmount {
+	currency := Amount{currency: p.currency}.combined(Amount{currency: other.currency})
+	return PreciseAmount{units: new(big.Int).Add(p.value(), other.value()), currency: currency}
+}
+
+// The amount rounded to the currency's minor units as given. Panics with an AmountRangeError if
+// the result is out of range.
+func (p PreciseAmount) Round(rounding RoundingMode) Amount {
+	minor := rounding.divide(p.value(), big.NewInt(rateScale))
+	if minor.CmpAbs(big.NewInt(MaxMinorUnits)) > 0 {
+		panic(amountRangeError(fmt.Sprintf("(%s)", p)))
+	}
+	return Amount{minor: minor.Int64(), currency: p.currency}
+}
+
+// The amount rounded as given, and what's left over to carry forward, so nothing is lost when an
+// accrual is posted a minor unit at a time.
+func (p PreciseAmount) Split(rounding RoundingMode) (Amount, PreciseAmount) {
+	rounded := p.Round(rounding)
+	rest := p.Add(rounded.Negative().Precise())
+	return rounded, rest
+}
+
+// The amount written with as many digits after the decimal point as it needs, but at least as
+// many as its currency has minor units, such as "0.00125" or "12.50" for dollars.
+func (p PreciseAmount) String() string {
+	value := p.value()
+	sign := ""
+	if value.Sign() < 0 {
+		sign = "-"
+	}
+	places := p.currency.MinorUnits() + RateDecimals
+	digits := new(big.Int).Abs(value).String()
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-places], digits[len(digits)-places:]
+	keep := len(strings.TrimRight(fraction, "0"))
+	if keep < p.currency.MinorUnits() {
+		keep = p.currency.MinorUnits()
+	}
+	if keep == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction[:keep]
+}
@@ -1,6 +1,11 @@
 package pkg_test
 
 import (
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing/quick"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rickducott/techproblems/atm/pkg"
@@ -13,7 +18,7 @@ var _ = Describe("Amount", func() {
 			Expect(amount).To(Equal(pkg.Cents(-115)))
 			Expect(pkg.ZeroAmount.GreaterThan(amount)).To(BeTrue())
 			Expect(amount.Negative()).To(Equal(pkg.Cents(115)))
-			Expect(amount.Add(pkg.Cents(115))).To(Equal(pkg.ZeroAmount))
+			Expect(amount.Add(pkg.Cents(115))).To(Equal(pkg.Zero(pkg.USD)))
 			Expect(amount.Subtract(pkg.Cents(115))).To(Equal(pkg.Cents(-230)))
 		})
 	})
@@ -71,5 +76,147 @@ var _ = Describe("Amount", func() {
 			amt = pkg.Cents(1099)
 			Expect(amt.String()).To(Equal("10.99"))
 		})
+
+		It("keeps the sign of amounts under a dollar", func() {
+			Expect(pkg.Cents(-5).String()).To(Equal("-0.05"))
+			amt, err := pkg.ParseAmount(pkg.Cents(-99).String())
+			Expect(err).To(BeNil())
+			Expect(amt).To(Equal(pkg.Cents(-99)))
+		})
+	})
+
+	Context("currencies", func() {
+		It("parses and writes each currency's minor units", func() {
+			yen, err := pkg.ParseAmountIn("1500", pkg.JPY)
+			Expect(err).To(BeNil())
+			Expect(yen).To(Equal(pkg.MinorAmount(pkg.JPY, 1500)))
+			Expect(yen.String()).To(Equal("1500"))
+			_, err = pkg.ParseAmountIn("1500.5", pkg.JPY)
+			Expect(err).To(MatchError(pkg.AmountParseError))
+
+			dinars, err := pkg.ParseAmountIn("-1.5", pkg.KWD)
+			Expect(err).To(BeNil())
+			Expect(dinars).To(Equal(pkg.MinorAmount(pkg.KWD, -1500)))
+			Expect(dinars.String()).To(Equal("-1.500"))
+			Expect(pkg.MajorAmount(pkg.KWD, 2).Minor()).To(Equal(int64(2000)))
+		})
+
+		It("looks up currency codes", func() {
+			Expect(pkg.ParseCurrency("eur")).To(Equal(pkg.EUR))
+			_, err := pkg.ParseCurrency("XYZ")
+			Expect(err).To(MatchError(pkg.UnknownCurrencyError))
+		})
+
+		It("refuses to mix currencies", func() {
+			euros := pkg.MajorAmount(pkg.EUR, 10)
+			_, err := euros.AddChecked(pkg.Dollars(10))
+			Expect(err).To(MatchError(pkg.CurrencyMismatchError))
+			_, err = euros.SubtractChecked(pkg.Dollars(10))
+			Expect(err).To(MatchError(pkg.CurrencyMismatchError))
+			Expect(func() { euros.Add(pkg.Dollars(10)) }).To(Panic())
+			Expect(func() { euros.GreaterThan(pkg.Dollars(10)) }).To(Panic())
+			Expect(euros.SameCurrency(pkg.Dollars(10))).To(BeFalse())
+		})
+
+		It("lets ZeroAmount go with any currency", func() {
+			euros := pkg.MajorAmount(pkg.EUR, 10)
+			Expect(euros.Add(pkg.ZeroAmount)).To(Equal(euros))
+			Expect(pkg.ZeroAmount.Subtract(euros)).To(Equal(euros.Negative()))
+			Expect(euros.GreaterThan(pkg.ZeroAmount)).To(BeTrue())
+		})
+	})
+
+	Context("range", func() {
+		var (
+			max = pkg.MinorAmount(pkg.USD, pkg.MaxMinorUnits)
+			min = max.Negative()
+		)
+
+		It("refuses to parse amounts out of range", func() {
+			for _, s := range []string{"99999999999999999", "-99999999999999999", "99999999999999999999999", "10000000000000000"} {
+				_, err := pkg.ParseAmount(s)
+				Expect(err).To(MatchError(pkg.AmountRangeError), s)
+			}
+			amt, err := pkg.ParseAmount("9999999999999999.99")
+			Expect(err).To(BeNil())
+			Expect(amt).To(Equal(max))
+			amt, err = pkg.ParseAmount("-9999999999999999.99")
+			Expect(err).To(BeNil())
+			Expect(amt).To(Equal(min))
+			_, err = pkg.ParseAmountIn("999999999999999.9999", pkg.KWD)
+			Expect(err).To(MatchError(pkg.AmountParseError))
+			_, err = pkg.ParseAmountIn("1000000000000000", pkg.KWD)
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+		})
+
+		It("returns errors from checked arithmetic rather than wrapping", func() {
+			_, err := max.AddChecked(pkg.Cents(1))
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			_, err = min.SubtractChecked(pkg.Cents(1))
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			_, err = max.TimesChecked(2)
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			_, err = pkg.MajorAmountChecked(pkg.USD, pkg.MaxMinorUnits)
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			Expect(max.SubtractChecked(max)).To(Equal(pkg.Zero(pkg.USD)))
+		})
+
+		It("panics rather than wrapping", func() {
+			Expect(func() { max.Add(pkg.Cents(1)) }).To(Panic())
+			Expect(func() { min.Subtract(max) }).To(Panic())
+			Expect(func() { max.Times(10) }).To(Panic())
+			Expect(func() { pkg.Dollars(pkg.MaxMinorUnits) }).To(Panic())
+			Expect(func() { pkg.Cents(pkg.MaxMinorUnits + 1) }).To(Panic())
+		})
+
+		It("round-trips over the whole range", func() {
+			limit := big.NewInt(pkg.MaxMinorUnits)
+			inRange := func(n *big.Int) bool { return n.CmpAbs(limit) <= 0 }
+			config := &quick.Config{
+				MaxCount: 10000,
+				Rand:     rand.New(rand.NewSource(1)),
+				Values: func(values []reflect.Value, r *rand.Rand) {
+					for i := range values {
+						values[i] = reflect.ValueOf(randomMinorUnits(r))
+					}
+				},
+			}
+			Expect(quick.Check(func(a, b int64) bool {
+				x, y := pkg.Cents(a), pkg.Cents(b)
+				if x.Negative().Negative() != x || !x.Add(x.Negative()).IsZero() {
+					return false
+				}
+				parsed, err := pkg.ParseAmount(x.String())
+				if err != nil || parsed != x {
+					return false
+				}
+				sum, err := x.AddChecked(y)
+				if inRange(new(big.Int).Add(big.NewInt(a), big.NewInt(b))) != (err == nil) {
+					return false
+				}
+				if err == nil && (sum.Subtract(y) != x || sum.Minor() != a+b) {
+					return false
+				}
+				difference, err := x.SubtractChecked(y)
+				if inRange(new(big.Int).Sub(big.NewInt(a), big.NewInt(b))) != (err == nil) {
+					return false
+				}
+				return err != nil || (difference.Add(y) == x && difference == x.Add(y.Negative()))
+			}, config)).To(Succeed())
+		})
 	})
 })
+
+// Minor units anywhere in the supported range, weighted towards the edges where overflow happens.
+func randomMinorUnits(r *rand.Rand) int64 {
+	switch r.Intn(4) {
+	case 0:
+		return pkg.MaxMinorUnits - r.Int63n(1000)
+	case 1:
+		return -pkg.MaxMinorUnits + r.Int63n(1000)
+	case 2:
+		return r.Int63n(2001) - 1000
+	default:
+		return r.Int63n(2*pkg.MaxMinorUnits+1) - pkg.MaxMinorUnits
+	}
+}
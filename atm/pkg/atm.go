@@ -1,152 +1,594 @@
 package pkg
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"sync"
 	"time"
 )
 
 var (
-	AuthorizationFailedError   = errors.New("Authorization failed.")
-	AuthorizationRequiredError = errors.New("Authorization required.")
-	NotAuthorizedError         = errors.New("No account currently authorized.")
-	InvalidAmountError         = errors.New("Invalid amount.")
-	NoMoneyError               = errors.New("Unable to process your withdrawal at this time.")
+	AuthorizationFailedError   = NewATMError("authorization_failed", AuthCategory, "Authorization failed.")
+	AuthorizationRequiredError = NewATMError("authorization_required", AuthCategory, "Authorization required.")
+	NotAuthorizedError         = NewATMError("not_authorized", AuthCategory, "No account currently authorized.")
+	AccountInUseError          = NewATMError("account_in_use", AuthCategory, "This account is already logged in.")
+	InvalidAmountError         = NewATMError("invalid_amount", InputCategory, "Invalid amount.")
+	NoMoneyError               = NewATMError("no_money", DeviceCategory, "Unable to process your withdrawal at this time.")
+	DispenseFailedError        = NewATMError("dispense_failed", DeviceCategory, "Unable to dispense cash at this time. Your account has not been charged.")
+	AtmClosedError             = NewATMError("atm_closed", DeviceCategory, "This atm is closed.")
+
+	UndispensedCashMemo = "undispensed cash"
+	FeeRefundMemo       = "fee refund"
 )
 
+// An atm can serve many customers at once. Authorize starts a session and returns its token,
+// which every other call takes to say which session it's for. Each account can only be in one
+// session at a time.
+//
+// Every call takes a context, and fails with the context's error if it's done before the call
+// gets going. Once cash is on its way out of the dispenser a withdrawal can no longer be
+// cancelled.
 type Atm interface {
-	Authorize(id, pin string) error
-	Withdraw(amount Amount) (*Transaction, error)
-	Deposit(amount Amount) error
-	Balance() (Amount, error)
-	History() ([]Transaction, error)
-	Logout() (string, error)
+	Authorize(ctx context.Context, id, pin string) (string, error)
+	// Call the listener whenever something happens to the session that the customer didn't ask for,
+	// such as being warned about or logged out for inactivity. Listeners are never called while the
+	// atm is busy, so they're free to call back into it.
+	Listen(ctx context.Context, token string, listener SessionListener) error
+	Withdraw(ctx context.Context, token string, amount Amount) (*Transaction, error)
+	Deposit(ctx context.Context, token string, amount Amount) error
+	Balance(ctx context.Context, token string) (Amount, error)
+	History(ctx context.Context, token string) ([]Transaction, error)
+	Logout(ctx context.Context, token string) (string, error)
+	// Stop taking new calls, wait for any withdrawals in progress to finish, and snapshot the
+	// journal if there is one. Calls made after Close fail with AtmClosedError.
+	Close() error
 }
 
 type Session struct {
+	Token      string
+	AccountId  string
+	LastActive time.Time
+	// Whether the customer has been warned that the session is about to time out.
+	Warned bool
+
+	listener SessionListener
+	timer    Timer
+}
+
+type SessionEventType string
+
+const (
+	// The session will be logged out soon unless the customer does something.
+	SessionWarning SessionEventType = "warning"
+	// The session has been logged out for inactivity.
+	SessionExpired SessionEventType = "expired"
+)
+
+type SessionEvent struct {
+	Type      SessionEventType
+	Token     string
 	AccountId string
-	Timer     int
+	// How long until the session times out. Always zero once it has expired.
+	Remaining time.Duration
+}
+
+type SessionListener func(event SessionEvent)
+
+// Create an atm. Sessions are logged out after logoutSeconds of inactivity, with a warning
+// warningSeconds beforehand; zero means no warning. If a journal is given, the accounts are first
+// brought up to date by replaying it, and every posting made from then on is recorded in it. The
+// atm closes itself when the context is done, but Close must still be called to wait for it.
+func NewAtm(ctx context.Context, clock Clock, id string, logoutSeconds, warningSeconds int, dispenser CashDispenser, accounts AccountStore, journal Journal) (Atm, error) {
+	return NewAtmWithExchange(ctx, clock, id, logoutSeconds, warningSeconds, dispenser, accounts, journal, nil)
 }
 
-func NewAtm(logoutSeconds int, accounts ...Account) (Atm, chan bool) {
+// Create an atm that can also dispense cash in currencies other than the account's, converted
+// through the exchange. The dispenser's notes in each currency are kept as separate inventories.
+// With a nil exchange, withdrawals must be in the account's currency.
+func NewAtmWithExchange(ctx context.Context, clock Clock, id string, logoutSeconds, warningSeconds int, dispenser CashDispenser, accounts AccountStore, journal Journal, exchange *Exchange) (Atm, error) {
+	if journal != nil {
+		if err := journal.Restore(accounts); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	atm := &atm{
-		money:    Dollars(10000),
-		accounts: Accounts(accounts...),
-		mutex:    &sync.Mutex{},
+		id:              id,
+		clock:           clock,
+		logout:          time.Duration(logoutSeconds) * time.Second,
+		warning:         time.Duration(warningSeconds) * time.Second,
+		dispenser:       dispenser,
+		accounts:        accounts,
+		journal:         journal,
+		exchange:        exchange,
+		sessions:        make(map[string]*Session),
+		accountSessions: make(map[string]*Session),
+		cancel:          cancel,
+		stopped:         make(chan struct{}),
+		withdrawals:     &sync.WaitGroup{},
+		mutex:           &sync.Mutex{},
 	}
-	done := make(chan bool)
-	go atm.Start(logoutSeconds, done)
-	return atm, done
+	go atm.run(ctx)
+	return atm, nil
 }
 
 type atm struct {
-	money    Amount
-	accounts map[string]Account
-	session  *Session
-	mutex    *sync.Mutex
-}
-
-func (a *atm) Start(logoutSeconds int, done chan bool) {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-done:
-			return
-		case <-ticker.C:
-			a.mutex.Lock()
-			if a.session != nil {
-				a.session.Timer += 1
-				if a.session.Timer >= logoutSeconds {
-					a.session = nil
-				}
-			}
-			a.mutex.Unlock()
-		}
+	id        string
+	clock     Clock
+	logout    time.Duration
+	warning   time.Duration
+	dispenser CashDispenser
+	reserved  Cassettes
+	accounts  AccountStore
+	journal   Journal
+	exchange  *Exchange
+	// Sessions by token, and by the id of the account they're for.
+	sessions        map[string]*Session
+	accountSessions map[string]*Session
+	closed          bool
+	cancel          context.CancelFunc
+	// Closed once the atm has stopped taking new calls.
+	stopped chan struct{}
+	// Withdrawals that have debited an account but not yet settled.
+	withdrawals *sync.WaitGroup
+	mutex       *sync.Mutex
+}
+
+// Each session has its own timer, so nothing runs while nobody is using the atm. Once the context
+// is done, the atm stops taking new calls and every session's timer is stopped.
+func (a *atm) run(ctx context.Context) {
+	defer close(a.stopped)
+	<-ctx.Done()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closed = true
+	for _, session := range a.sessions {
+		session.timer.Stop()
 	}
 }
 
-func (a *atm) Authorize(id, pin string) error {
+func (a *atm) Close() error {
+	a.cancel()
+	<-a.stopped
+	a.withdrawals.Wait()
+	if a.journal != nil {
+		return a.journal.Compact(a.accounts)
+	}
+	return nil
+}
+
+// Check that a call can go ahead. Must be called with the mutex held.
+func (a *atm) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.closed {
+		return AtmClosedError
+	}
+	return nil
+}
+
+// Whether sessions are warned before they time out.
+func (a *atm) warns() bool {
+	return a.warning > 0 && a.warning < a.logout
+}
+
+// Set the session's timer for whichever of its warning or timeout comes next. Must be called with
+// the mutex held.
+func (a *atm) schedule(session *Session) {
+	if session.timer != nil {
+		session.timer.Stop()
+	}
+	deadline := session.LastActive.Add(a.logout)
+	if a.warns() && !session.Warned {
+		deadline = deadline.Add(-a.warning)
+	}
+	session.timer = a.clock.AfterFunc(deadline.Sub(a.clock.Now()), func() {
+		a.timeout(session)
+	})
+}
+
+// Warn or log out a session when its timer fires. The session may have been active or ended since
+// the timer was set, so what's due is worked out again from the clock.
+func (a *atm) timeout(session *Session) {
+	a.mutex.Lock()
+	if a.closed || a.sessions[session.Token] != session {
+		a.mutex.Unlock()
+		return
+	}
+	now := a.clock.Now()
+	expires := session.LastActive.Add(a.logout)
+	event := SessionEvent{Token: session.Token, AccountId: session.AccountId}
+	if !now.Before(expires) {
+		a.endSession(session)
+		event.Type = SessionExpired
+	} else if a.warns() && !session.Warned && !now.Before(expires.Add(-a.warning)) {
+		session.Warned = true
+		a.schedule(session)
+		event.Type = SessionWarning
+		event.Remaining = expires.Sub(now)
+	} else {
+		a.schedule(session)
+		a.mutex.Unlock()
+		return
+	}
+	listener := session.listener
+	a.mutex.Unlock()
+	if listener != nil {
+		listener(event)
+	}
+}
+
+// Must be called with the mutex held.
+func (a *atm) endSession(session *Session) {
+	session.timer.Stop()
+	delete(a.sessions, session.Token)
+	delete(a.accountSessions, session.AccountId)
+}
+
+// The id of the account for a session, marking the session as active. Must be called with the
+// mutex held.
+func (a *atm) sessionAccountId(ctx context.Context, token string) (string, error) {
+	if err := a.check(ctx); err != nil {
+		return "", err
+	}
+	session, ok := a.sessions[token]
+	if !ok {
+		return "", AuthorizationRequiredError
+	}
+	session.LastActive = a.clock.Now()
+	session.Warned = false
+	a.schedule(session)
+	return session.AccountId, nil
+}
+
+// The account for a session, marking the session as active. Must be called with the mutex held.
+func (a *atm) sessionAccount(ctx context.Context, token string) (Account, error) {
+	id, err := a.sessionAccountId(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return a.accounts.Get(id)
+}
+
+func (a *atm) Authorize(ctx context.Context, id, pin string) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.check(ctx); err != nil {
+		return "", err
+	}
+	account, err := a.accounts.Get(id)
+	if errors.Is(err, AccountNotFoundError) || (err == nil && !account.Authorize(pin)) {
+		return "", AuthorizationFailedError
+	} else if err != nil {
+		return "", err
+	}
+	if _, ok := a.accountSessions[id]; ok {
+		return "", AccountInUseError
+	}
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	session := &Session{
+		Token:      token,
+		AccountId:  id,
+		LastActive: a.clock.Now(),
+	}
+	a.sessions[session.Token] = session
+	a.accountSessions[id] = session
+	a.schedule(session)
+	return session.Token, nil
+}
+
+func (a *atm) Listen(ctx context.Context, token string, listener SessionListener) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	account, ok := a.accounts[id]
-	if !ok || !account.Authorize(pin) {
-		return AuthorizationFailedError
+	if err := a.check(ctx); err != nil {
+		return err
 	}
-	a.session = &Session{
-		AccountId: id,
-		Timer:     0,
+	session, ok := a.sessions[token]
+	if !ok {
+		return AuthorizationRequiredError
 	}
+	session.listener = listener
 	return nil
 }
 
-func (a *atm) transaction(amount Amount) (*Transaction, error) {
+// Generate a random, unguessable session token.
+func newSessionToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// Post to the session's account, returning the account's id along with the transaction.
+func (a *atm) transaction(ctx context.Context, token string, amount Amount, details TransactionDetails) (string, *Transaction, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	if a.session == nil {
-		return nil, AuthorizationRequiredError
+	accountId, err := a.sessionAccountId(ctx, token)
+	if err != nil {
+		return "", nil, err
+	}
+	txn, err := a.post(accountId, amount, details)
+	if err != nil {
+		return "", nil, err
+	}
+	a.checkpoint()
+	return accountId, txn, nil
+}
+
+// Post to an account while holding its lock. The store records the posting before the account
+// applies it, so a posting that fails leaves the balance untouched. Must be called with the mutex
+// held.
+func (a *atm) post(accountId string, amount Amount, details TransactionDetails) (*Transaction, error) {
+	unlock, err := a.accounts.Lock(accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	account, err := a.accounts.Get(accountId)
+	if err != nil {
+		return nil, err
+	}
+	details.TerminalId = a.id
+	txn, err := account.Prepare(amount, details)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.accounts.Post(account, txn); err != nil {
+		return nil, err
 	}
-	account := a.accounts[a.session.AccountId]
-	a.session.Timer = 0
-	return account.Transaction(amount)
+	return txn, nil
 }
 
-func (a *atm) Withdraw(amount Amount) (*Transaction, error) {
-	if !amount.GreaterThan(ZeroAmount) || !amount.MultipleOf(Dollars(20)) {
+// Must be called with the mutex held.
+func (a *atm) checkpoint() {
+	if a.journal != nil {
+		// A failed compaction leaves the journal as it was, so it's safe to carry on and try again
+		// after the next transaction.
+		_ = a.journal.Checkpoint(a.accounts)
+	}
+}
+
+// Withdrawals happen in phases. First the notes are reserved and the account is debited for the
+// full amount, so the withdrawal is on record before any cash moves. Then the notes are dispensed.
+// If they all come out, that's it; otherwise whatever was held back is credited back to the
+// account, along with any overdraft fee the dispensed cash alone wouldn't have incurred. If the
+// context is done before dispensing starts, nothing is dispensed and the withdrawal is rolled back.
+//
+// Cash in another currency than the account's is charged at the exchange's rate when it's held,
+// and anything held back is credited at that same rate.
+func (a *atm) Withdraw(ctx context.Context, token string, amount Amount) (*Transaction, error) {
+	if !amount.GreaterThan(ZeroAmount) {
 		return nil, InvalidAmountError
 	}
-	if a.money == ZeroAmount {
-		return nil, NoMoneyError
+	rate, err := a.rate(ctx, token, amount.Currency())
+	if err != nil {
+		return nil, err
 	}
-	if amount.GreaterThan(a.money) {
-		amount = a.money
+	accountId, txn, err := a.hold(ctx, token, amount, rate)
+	if err != nil {
+		return nil, err
 	}
-	txn, err := a.transaction(amount.Negative())
+	defer a.withdrawals.Done()
+	if err := ctx.Err(); err != nil {
+		// Settling as if nothing came out credits back the whole withdrawal.
+		if _, settleErr := a.settle(accountId, txn, NewDispenseResult(txn.Dispensed, nil)); !errors.Is(settleErr, DispenseFailedError) {
+			return nil, settleErr
+		}
+		return nil, err
+	}
+	result := a.dispenser.Dispense(txn.Dispensed)
+	return a.settle(accountId, txn, result)
+}
+
+// The rate to charge for cash in the given currency, or nil if it's the account's own. Rates are
+// looked up without holding the mutex, as a provider may have to go over the network.
+func (a *atm) rate(ctx context.Context, token string, currency Currency) (*Rate, error) {
+	a.mutex.Lock()
+	account, err := a.sessionAccount(ctx, token)
+	a.mutex.Unlock()
 	if err != nil {
 		return nil, err
-	} else {
-		a.money = a.money.Add(txn.Amount)
-		return txn, err
 	}
+	home := account.Currency()
+	if currency == "" || currency == home {
+		return nil, nil
+	}
+	if a.exchange == nil {
+		return nil, currencyMismatchError(home, currency)
+	}
+	rate, err := a.exchange.rate(ctx, currency, home)
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
 }
 
-func (a *atm) Deposit(amount Amount) error {
+// Reserve notes for a withdrawal and debit the account for them as a single step, so concurrent
+// withdrawals can never count on the same notes. The withdrawal is counted as in progress until
+// it's settled. Foreign notes are debited at the given rate.
+func (a *atm) hold(ctx context.Context, token string, amount Amount, rate *Rate) (string, *Transaction, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	accountId, err := a.sessionAccountId(ctx, token)
+	if err != nil {
+		return "", nil, err
+	}
+	cassettes := a.available().In(amount.Currency())
+	money := cassettes.Total()
+	if money.IsZero() {
+		return "", nil, NoMoneyError
+	}
+	if !amount.MultipleOf(cassettes.Unit()) {
+		return "", nil, InvalidAmountError
+	}
+	if amount.GreaterThan(money) {
+		amount = money
+	}
+	notes, ok := cassettes.Dispense(amount)
+	if !ok {
+		// There's cash, just not in notes that make up this amount, so another amount may work.
+		return "", nil, InvalidAmountError
+	}
+	debit := notes.Total()
+	details := TransactionDetails{Dispensed: notes}
+	if rate != nil {
+		home, err := rate.ConvertChecked(debit, a.exchange.rounding())
+		if err != nil {
+			return "", nil, err
+		}
+		details.Conversion = &Conversion{Foreign: debit, Home: home, Rate: *rate}
+		debit = home
+	}
+	txn, err := a.post(accountId, debit.Negative(), details)
+	if err != nil {
+		return "", nil, err
+	}
+	a.reserved = NewCassettes(append(a.reserved, notes...)...)
+	a.withdrawals.Add(1)
+	a.checkpoint()
+	return accountId, txn, nil
+}
+
+// The notes in the dispenser that aren't reserved for a withdrawal in progress. Notes leave the
+// dispenser before their reservation is released, so for a moment they're counted twice; that
+// only ever under-reports what's available. Must be called with the mutex held.
+func (a *atm) available() Cassettes {
+	return NewCassettes(a.dispenser.Cassettes().Remove(a.reserved)...)
+}
+
+// Release the reservation for a withdrawal once the dispenser is done with it, rolling back
+// whatever didn't come out.
+func (a *atm) settle(accountId string, txn *Transaction, result DispenseResult) (*Transaction, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.reserved = NewCassettes(a.reserved.Remove(txn.Dispensed)...)
+	if result.Status == DispenseComplete {
+		return txn, nil
+	}
+	defer a.checkpoint()
+	if err := a.rollback(accountId, txn, result.Dispensed); err != nil {
+		return nil, err
+	}
+	if result.Status == DispenseFailed {
+		return nil, DispenseFailedError
+	}
+	return txn, nil
+}
+
+// Credit the account for the notes of a withdrawal that weren't dispensed. The reversals are
+// attached to the withdrawal transaction. Must be called with the mutex held.
+func (a *atm) rollback(accountId string, txn *Transaction, dispensed Cassettes) error {
+	retained := NewCassettes(txn.Dispensed.Remove(dispensed)...)
+	if retained.Total().IsZero() {
+		return nil
+	}
+	// What the customer is charged for the cash they got, and credited back for the rest.
+	kept := dispensed.Total()
+	credit := retained.Total()
+	if txn.Conversion != nil {
+		var err error
+		if kept, err = txn.Conversion.Rate.ConvertChecked(kept, a.exchange.rounding()); err != nil {
+			return err
+		}
+		credit = txn.Amount.Negative().Subtract(kept)
+	}
+	reversal, err := a.post(accountId, credit, TransactionDetails{
+		Type:      ReversalTransaction,
+		ParentId:  txn.Id,
+		Memo:      UndispensedCashMemo,
+		Dispensed: retained,
+	})
+	if err != nil {
+		return err
+	}
+	txn.Reversals = append(txn.Reversals, *reversal)
+
+	// The fees are refunded if the policy wouldn't have charged any for the cash actually kept,
+	// which is always the case when none came out.
+	account, err := a.accounts.Get(accountId)
+	if err != nil {
+		return err
+	}
+	opening := txn.Balance.Subtract(txn.Amount)
+	if fee, err := account.Policy().Charge(opening, kept.Negative(), ZeroAmount); err == nil && fee.GreaterThan(ZeroAmount) {
+		return nil
+	}
+	for _, fee := range txn.Fees {
+		refund, err := a.post(accountId, fee.Amount.Abs(), TransactionDetails{
+			Type:     ReversalTransaction,
+			ParentId: fee.Id,
+			Memo:     FeeRefundMemo,
+		})
+		if err != nil {
+			return err
+		}
+		txn.Reversals = append(txn.Reversals, *refund)
+	}
+	return nil
+}
+
+func (a *atm) Deposit(ctx context.Context, token string, amount Amount) error {
 	if !amount.GreaterThan(ZeroAmount) {
 		return InvalidAmountError
 	}
-	_, err := a.transaction(amount)
+	_, _, err := a.transaction(ctx, token, amount, TransactionDetails{})
 	return err
 }
 
-func (a *atm) Balance() (Amount, error) {
+func (a *atm) Balance(ctx context.Context, token string) (Amount, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	if a.session == nil {
-		return ZeroAmount, AuthorizationRequiredError
+	account, err := a.sessionAccount(ctx, token)
+	if err != nil {
+		return ZeroAmount, err
 	}
-	account := a.accounts[a.session.AccountId]
-	a.session.Timer = 0
 	return account.Balance(), nil
 }
 
-func (a *atm) History() ([]Transaction, error) {
+// Parse an amount entered during a session in the given currency code, or if that's empty, in the
+// currency of the session's account. Without a session the default currency is assumed, and the
+// atm will refuse the amount anyway.
+func parseSessionAmount(ctx context.Context, atm Atm, token, amount, code string) (Amount, error) {
+	if code != "" {
+		currency, err := ParseCurrency(code)
+		if err != nil {
+			return ZeroAmount, err
+		}
+		return ParseAmountIn(amount, currency)
+	}
+	currency := DefaultCurrency
+	if balance, err := atm.Balance(ctx, token); err == nil && balance.Currency() != "" {
+		currency = balance.Currency()
+	}
+	return ParseAmountIn(amount, currency)
+}
+
+func (a *atm) History(ctx context.Context, token string) ([]Transaction, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	if a.session == nil {
-		return nil, AuthorizationRequiredError
+	account, err := a.sessionAccount(ctx, token)
+	if err != nil {
+		return nil, err
 	}
-	account := a.accounts[a.session.AccountId]
-	a.session.Timer = 0
 	return account.History(), nil
 }
 
-func (a *atm) Logout() (string, error) {
+func (a *atm) Logout(ctx context.Context, token string) (string, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	if a.session != nil {
-		accountId := a.session.AccountId
-		a.session = nil
-		return accountId, nil
+	if err := a.check(ctx); err != nil {
+		return "", err
+	}
+	if session, ok := a.sessions[token]; ok {
+		a.endSession(session)
+		return session.AccountId, nil
 	} else {
 		return "", NotAuthorizedError
 	}
@@ -1,6 +1,10 @@
 package pkg_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -18,28 +22,38 @@ var _ = Describe("Atm", func() {
 	var (
 		account pkg.Account
 		atm     pkg.Atm
-		done    chan bool
+		token   string
+		clock   *pkg.ManualClock
 
-		amount = pkg.Dollars(20000)
+		ctx       = context.Background()
+		amount    = pkg.Dollars(20000)
+		cassettes = []pkg.Cassette{
+			{Denomination: pkg.Dollars(50), Count: 100},
+			{Denomination: pkg.Dollars(20), Count: 250},
+		}
 	)
 
 	BeforeEach(func() {
-		account = pkg.NewAccount(id, pin, amount)
-		atm, done = pkg.NewAtm(1, account)
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account = pkg.NewAccount(clock, id, pin, amount, pkg.DefaultOverdraftPolicy)
+		token = ""
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
 	})
 
 	AfterEach(func() {
-		// prevent goroutine leak
-		done <- true
+		Expect(atm.Close()).To(Succeed())
 	})
 
 	authorize := func() {
-		err := atm.Authorize(id, pin)
+		var err error
+		token, err = atm.Authorize(ctx, id, pin)
 		Expect(err).To(BeNil())
 	}
 
 	expectBalance := func(expected pkg.Amount, expectedErr error) {
-		balance, err := atm.Balance()
+		balance, err := atm.Balance(ctx, token)
 		if expectedErr == nil {
 			Expect(err).To(BeNil())
 			Expect(balance).To(Equal(expected))
@@ -49,7 +63,7 @@ var _ = Describe("Atm", func() {
 	}
 
 	expectDeposit := func(amount pkg.Amount, expected error) {
-		err := atm.Deposit(amount)
+		err := atm.Deposit(ctx, token, amount)
 		if expected == nil {
 			Expect(err).To(BeNil())
 		} else {
@@ -58,7 +72,7 @@ var _ = Describe("Atm", func() {
 	}
 
 	expectWithdraw := func(amount, expectedAmt pkg.Amount, expectedErr error) {
-		withdrawTxn, err := atm.Withdraw(amount)
+		withdrawTxn, err := atm.Withdraw(ctx, token, amount)
 		if expectedErr == nil {
 			Expect(err).To(BeNil())
 			Expect(withdrawTxn.Amount).To(Equal(expectedAmt))
@@ -73,7 +87,7 @@ var _ = Describe("Atm", func() {
 		expectDeposit(pkg.Dollars(1000), nil)
 		expectWithdraw(pkg.Dollars(2000), pkg.Dollars(-2000), nil)
 		expectBalance(pkg.Dollars(19000), nil)
-		atm.Logout()
+		atm.Logout(ctx, token)
 	})
 
 	It("handles atm running out of money", func() {
@@ -81,26 +95,405 @@ var _ = Describe("Atm", func() {
 		expectDeposit(pkg.Dollars(20000), nil)
 		expectWithdraw(pkg.Dollars(20000), pkg.Dollars(-10000), nil)
 		expectBalance(pkg.Dollars(30000), nil)
-		atm.Logout()
+		atm.Logout(ctx, token)
+	})
+
+	It("records the notes dispensed", func() {
+		authorize()
+		txn, err := atm.Withdraw(ctx, token, pkg.Dollars(60))
+		Expect(err).To(BeNil())
+		Expect(txn.Dispensed).To(Equal(pkg.Cassettes{{Denomination: pkg.Dollars(20), Count: 3}}))
+		history, err := atm.History(ctx, token)
+		Expect(err).To(BeNil())
+		Expect(history[0].Dispensed).To(Equal(txn.Dispensed))
+	})
+
+	It("validates withdrawals against the loaded denominations", func() {
+		authorize()
+		expectWithdraw(pkg.Dollars(15), pkg.ZeroAmount, pkg.InvalidAmountError)
+		expectWithdraw(pkg.Dollars(30), pkg.ZeroAmount, pkg.InvalidAmountError)
+		expectWithdraw(pkg.Dollars(70), pkg.Dollars(-70), nil)
+		expectBalance(pkg.Dollars(19930), nil)
 	})
 
 	It("properly errors when not authorized", func() {
 		expectDeposit(pkg.Dollars(20000), pkg.AuthorizationRequiredError)
 		expectWithdraw(pkg.Dollars(20000), pkg.ZeroAmount, pkg.AuthorizationRequiredError)
 		expectBalance(pkg.ZeroAmount, pkg.AuthorizationRequiredError)
-		_, err := atm.History()
+		_, err := atm.History(ctx, token)
 		Expect(err).To(Equal(pkg.AuthorizationRequiredError))
 	})
 
 	It("properly logs out after logout seconds", func() {
 		authorize()
-		time.Sleep(1500 * time.Millisecond)
+		clock.Advance(500 * time.Millisecond)
+		expectBalance(amount, nil)
+		clock.Advance(999 * time.Millisecond)
+		expectBalance(amount, nil)
+		clock.Advance(time.Second)
+		expectBalance(pkg.ZeroAmount, pkg.AuthorizationRequiredError)
+		_, err := atm.Logout(ctx, token)
+		Expect(err).To(Equal(pkg.NotAuthorizedError))
+	})
+
+	It("stamps transactions with the atm's clock", func() {
+		authorize()
+		clock.Advance(500 * time.Millisecond)
+		txn, err := atm.Withdraw(ctx, token, pkg.Dollars(20))
+		Expect(err).To(BeNil())
+		Expect(txn.Date).To(Equal(clock.Now()))
+	})
+
+	It("leaves the balance and the cash alone when a posting can't be stored", func() {
+		Expect(atm.Close()).To(Succeed())
+		dispenser := pkg.NewCassetteDispenser(cassettes...)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, dispenser, &failingStore{pkg.NewMemoryStore(account)}, nil)
+		Expect(err).To(BeNil())
+		authorize()
+		_, err = atm.Withdraw(ctx, token, pkg.Dollars(100))
+		Expect(err).To(MatchError("disk full"))
+		expectBalance(amount, nil)
+		Expect(account.History()).To(BeEmpty())
+		Expect(dispenser.Cassettes()).To(Equal(pkg.NewCassettes(cassettes...)))
+	})
+
+	Context("when the dispenser jams", func() {
+		var (
+			jammed *jammingDispenser
+		)
+
+		BeforeEach(func() {
+			Expect(atm.Close()).To(Succeed())
+			jammed = &jammingDispenser{
+				CashDispenser: pkg.NewCassetteDispenser(cassettes...),
+			}
+			var err error
+			atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, jammed, pkg.NewMemoryStore(account), nil)
+			Expect(err).To(BeNil())
+		})
+
+		It("credits back notes that weren't dispensed", func() {
+			authorize()
+			jammed.notes = 1
+			txn, err := atm.Withdraw(ctx, token, pkg.Dollars(100))
+			Expect(err).To(BeNil())
+			Expect(txn.Amount).To(Equal(pkg.Dollars(-100)))
+			Expect(txn.NetAmount()).To(Equal(pkg.Dollars(-50)))
+			Expect(txn.Reversals).To(HaveLen(1))
+			Expect(txn.Reversals[0].Type).To(Equal(pkg.ReversalTransaction))
+			Expect(txn.Reversals[0].ParentId).To(Equal(txn.Id))
+			Expect(txn.Reversals[0].Dispensed).To(Equal(pkg.Cassettes{{Denomination: pkg.Dollars(50), Count: 1}}))
+			Expect(txn.ClosingBalance()).To(Equal(pkg.Dollars(19950)))
+			expectBalance(pkg.Dollars(19950), nil)
+			history, _ := atm.History(ctx, token)
+			Expect(history).To(HaveLen(2))
+		})
+
+		It("credits back everything when nothing comes out", func() {
+			authorize()
+			expectWithdraw(pkg.Dollars(100), pkg.ZeroAmount, pkg.DispenseFailedError)
+			expectBalance(amount, nil)
+			history, _ := atm.History(ctx, token)
+			Expect(history).To(HaveLen(2))
+			Expect(history[1].Amount).To(Equal(pkg.Dollars(100)))
+		})
+
+		It("refunds overdraft fees the dispensed cash wouldn't have incurred", func() {
+			poor := pkg.NewAccount(clock, "23456", pin, pkg.Dollars(50), pkg.DefaultOverdraftPolicy)
+			var err error
+			Expect(atm.Close()).To(Succeed())
+			atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, jammed, pkg.NewMemoryStore(poor), nil)
+			Expect(err).To(BeNil())
+			token, err = atm.Authorize(ctx, "23456", pin)
+			Expect(err).To(BeNil())
+			jammed.notes = 1
+			txn, err := atm.Withdraw(ctx, token, pkg.Dollars(100))
+			Expect(err).To(BeNil())
+			Expect(txn.Fees).To(HaveLen(1))
+			Expect(txn.Refunded(txn.Fees[0])).To(BeTrue())
+			expectBalance(pkg.Zero(pkg.USD), nil)
+			Expect(pkg.WithdrawMessage(pkg.Dollars(100), txn)).NotTo(ContainSubstring("overdraft fee"))
+		})
+
+		It("refunds the overdraft fee of an account that was already overdrawn when nothing comes out", func() {
+			overdrawn := pkg.NewAccount(clock, "23456", pin, pkg.Dollars(-10), pkg.OverdraftPolicy{
+				AllowWhileOverdrawn: true,
+				Fees:                pkg.FlatFee(pkg.Dollars(5)),
+			})
+			var err error
+			Expect(atm.Close()).To(Succeed())
+			atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, jammed, pkg.NewMemoryStore(overdrawn), nil)
+			Expect(err).To(BeNil())
+			token, err = atm.Authorize(ctx, "23456", pin)
+			Expect(err).To(BeNil())
+			expectWithdraw(pkg.Dollars(20), pkg.ZeroAmount, pkg.DispenseFailedError)
+			expectBalance(pkg.Dollars(-10), nil)
+		})
+	})
+
+	It("keeps cash balanced across concurrent sessions", func() {
+		loaded := []pkg.Cassette{
+			{Denomination: pkg.Dollars(50), Count: 40},
+			{Denomination: pkg.Dollars(20), Count: 100},
+		}
+		dispenser := pkg.NewCassetteDispenser(loaded...)
+		accounts := make([]pkg.Account, 20)
+		for i := range accounts {
+			accounts[i] = pkg.NewAccount(clock, fmt.Sprintf("%d", i), pin, amount, pkg.DefaultOverdraftPolicy)
+		}
+		Expect(atm.Close()).To(Succeed())
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, dispenser, pkg.NewMemoryStore(accounts...), nil)
+		Expect(err).To(BeNil())
+
+		var (
+			wg        sync.WaitGroup
+			mutex     sync.Mutex
+			dispensed = pkg.ZeroAmount
+		)
+		for i := range accounts {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				token, err := atm.Authorize(ctx, fmt.Sprintf("%d", i), pin)
+				Expect(err).To(BeNil())
+				for j := 0; j < 20; j++ {
+					txn, err := atm.Withdraw(ctx, token, pkg.Dollars(int64(20*(1+(i+j)%10))))
+					if err != nil {
+						Expect(err).To(Or(Equal(pkg.NoMoneyError), Equal(pkg.InvalidAmountError)))
+						continue
+					}
+					mutex.Lock()
+					dispensed = dispensed.Add(txn.NetAmount().Abs())
+					mutex.Unlock()
+					_, _ = atm.Balance(ctx, token)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		total := pkg.NewCassettes(loaded...).Total()
+		Expect(dispensed.Add(dispenser.Cassettes().Total())).To(Equal(total))
+		debited := pkg.ZeroAmount
+		for _, account := range accounts {
+			debited = debited.Add(amount.Subtract(account.Balance()))
+		}
+		Expect(debited).To(Equal(dispensed))
+	})
+
+	It("keeps sessions apart", func() {
+		other := pkg.NewAccount(clock, "23456", pin, pkg.Dollars(50), pkg.DefaultOverdraftPolicy)
+		Expect(atm.Close()).To(Succeed())
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account, other), nil)
+		Expect(err).To(BeNil())
+		authorize()
+		otherToken, err := atm.Authorize(ctx, "23456", pin)
+		Expect(err).To(BeNil())
+		Expect(otherToken).NotTo(Equal(token))
+		expectBalance(amount, nil)
+		Expect(atm.Balance(ctx, otherToken)).To(Equal(pkg.Dollars(50)))
+		Expect(atm.Logout(ctx, otherToken)).To(Equal("23456"))
+		expectBalance(amount, nil)
+		_, err = atm.Balance(ctx, otherToken)
+		Expect(err).To(Equal(pkg.AuthorizationRequiredError))
+	})
+
+	It("times out sessions separately", func() {
+		other := pkg.NewAccount(clock, "23456", pin, pkg.Dollars(50), pkg.DefaultOverdraftPolicy)
+		Expect(atm.Close()).To(Succeed())
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account, other), nil)
+		Expect(err).To(BeNil())
+		authorize()
+		clock.Advance(500 * time.Millisecond)
+		otherToken, err := atm.Authorize(ctx, "23456", pin)
+		Expect(err).To(BeNil())
+		clock.Advance(500 * time.Millisecond)
 		expectBalance(pkg.ZeroAmount, pkg.AuthorizationRequiredError)
+		Expect(atm.Balance(ctx, otherToken)).To(Equal(pkg.Dollars(50)))
+	})
+
+	Context("with a timeout warning", func() {
+		var events []pkg.SessionEvent
+
+		BeforeEach(func() {
+			Expect(atm.Close()).To(Succeed())
+			var err error
+			atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 30, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account), nil)
+			Expect(err).To(BeNil())
+			events = nil
+			authorize()
+			Expect(atm.Listen(ctx, token, func(event pkg.SessionEvent) {
+				events = append(events, event)
+			})).To(Succeed())
+		})
+
+		It("warns before logging out", func() {
+			clock.Advance(89 * time.Second)
+			Expect(events).To(BeEmpty())
+			clock.Advance(time.Second)
+			Expect(events).To(Equal([]pkg.SessionEvent{
+				{Type: pkg.SessionWarning, Token: token, AccountId: id, Remaining: 30 * time.Second},
+			}))
+			clock.Advance(30 * time.Second)
+			Expect(events).To(HaveLen(2))
+			Expect(events[1]).To(Equal(pkg.SessionEvent{Type: pkg.SessionExpired, Token: token, AccountId: id}))
+			expectBalance(pkg.ZeroAmount, pkg.AuthorizationRequiredError)
+		})
+
+		It("starts over when the customer carries on", func() {
+			clock.Advance(100 * time.Second)
+			expectBalance(amount, nil)
+			clock.Advance(100 * time.Second)
+			Expect(events).To(HaveLen(2))
+			Expect(events[1].Type).To(Equal(pkg.SessionWarning))
+			Expect(events[1].Remaining).To(Equal(30 * time.Second))
+			expectBalance(amount, nil)
+		})
+
+		It("says nothing about sessions logged out by the customer", func() {
+			_, err := atm.Logout(ctx, token)
+			Expect(err).To(BeNil())
+			clock.Advance(time.Hour)
+			Expect(events).To(BeEmpty())
+		})
+
+		It("frees the account once the session expires", func() {
+			clock.Advance(120 * time.Second)
+			authorize()
+		})
+	})
+
+	It("doesn't listen to unknown tokens", func() {
+		Expect(atm.Listen(ctx, "not-a-token", func(pkg.SessionEvent) {})).To(Equal(pkg.AuthorizationRequiredError))
+	})
+
+	It("doesn't let an account log in twice", func() {
+		authorize()
+		_, err := atm.Authorize(ctx, id, pin)
+		Expect(err).To(Equal(pkg.AccountInUseError))
+		_, _ = atm.Logout(ctx, token)
+		authorize()
+	})
+
+	It("rejects unknown tokens", func() {
+		authorize()
+		_, err := atm.Balance(ctx, "not-a-token")
+		Expect(err).To(Equal(pkg.AuthorizationRequiredError))
+		_, err = atm.Logout(ctx, "not-a-token")
+		Expect(err).To(Equal(pkg.NotAuthorizedError))
+	})
+
+	It("stops taking calls once closed", func() {
+		authorize()
+		Expect(atm.Close()).To(Succeed())
+		_, err := atm.Balance(ctx, token)
+		Expect(err).To(Equal(pkg.AtmClosedError))
+		_, err = atm.Authorize(ctx, id, pin)
+		Expect(err).To(Equal(pkg.AtmClosedError))
+	})
+
+	It("closes when its context is done", func() {
+		Expect(atm.Close()).To(Succeed())
+		atmCtx, cancel := context.WithCancel(ctx)
+		var err error
+		atm, err = pkg.NewAtm(atmCtx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		cancel()
+		Eventually(func() error {
+			_, err := atm.Authorize(ctx, id, pin)
+			return err
+		}).Should(Equal(pkg.AtmClosedError))
+	})
+
+	It("fails calls whose context is done", func() {
+		authorize()
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := atm.Withdraw(cancelled, token, pkg.Dollars(20))
+		Expect(err).To(Equal(context.Canceled))
+		_, err = atm.Balance(cancelled, token)
+		Expect(err).To(Equal(context.Canceled))
+		expectBalance(amount, nil)
+	})
+
+	It("waits for withdrawals in progress before closing", func() {
+		Expect(atm.Close()).To(Succeed())
+		blocked := &blockingDispenser{
+			CashDispenser: pkg.NewCassetteDispenser(cassettes...),
+			started:       make(chan bool),
+			release:       make(chan bool),
+		}
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, blocked, pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		authorize()
+		withdrawn := make(chan error)
+		go func() {
+			_, err := atm.Withdraw(ctx, token, pkg.Dollars(20))
+			withdrawn <- err
+		}()
+		<-blocked.started
+		closed := make(chan error)
+		go func() {
+			closed <- atm.Close()
+		}()
+		Consistently(closed).ShouldNot(Receive())
+		close(blocked.release)
+		Expect(<-withdrawn).To(BeNil())
+		Eventually(closed).Should(Receive(BeNil()))
+		Expect(account.Balance()).To(Equal(amount.Subtract(pkg.Dollars(20))))
 	})
 
 	It("handles invalid auth", func() {
-		err := atm.Authorize(id, "2345")
+		_, err := atm.Authorize(ctx, id, "2345")
 		Expect(err).To(Equal(pkg.AuthorizationFailedError))
 	})
 
 })
+
+// A store that can't record postings.
+type failingStore struct {
+	pkg.AccountStore
+}
+
+func (s *failingStore) Post(account pkg.Account, txn *pkg.Transaction) error {
+	return errors.New("disk full")
+}
+
+// A dispenser that only manages to pay out the first few notes it's asked for.
+type jammingDispenser struct {
+	pkg.CashDispenser
+	notes int
+}
+
+func (d *jammingDispenser) Dispense(notes pkg.Cassettes) pkg.DispenseResult {
+	remaining := d.notes
+	var partial pkg.Cassettes
+	for _, note := range notes {
+		count := note.Count
+		if count > remaining {
+			count = remaining
+		}
+		remaining -= count
+		partial = append(partial, pkg.Cassette{Denomination: note.Denomination, Count: count})
+	}
+	result := d.CashDispenser.Dispense(pkg.NewCassettes(partial...))
+	return pkg.NewDispenseResult(notes, result.Dispensed)
+}
+
+// A dispenser that waits to be released before paying out.
+type blockingDispenser struct {
+	pkg.CashDispenser
+	started chan bool
+	release chan bool
+}
+
+func (d *blockingDispenser) Dispense(notes pkg.Cassettes) pkg.DispenseResult {
+	d.started <- true
+	<-d.release
+	return d.CashDispenser.Dispense(notes)
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"sort"
+)
+
+// A cassette is a stack of notes of a single denomination. Cassettes describe both the cash
+// loaded into an atm and the notes paid out by a withdrawal. An atm can hold notes in several
+// currencies; each currency is its own inventory, picked out with Cassettes.In.
+type Cassette struct {
+	Denomination Amount
+	Count        int
+}
+
+func (c Cassette) Total() Amount {
+	return c.Denomination.Times(c.Count)
+}
+
+type Cassettes []Cassette
+
+// Build a set of cassettes, merging notes of the same denomination and dropping empty or invalid
+// cassettes. The result is ordered by currency, then from the largest denomination to the smallest.
+func NewCassettes(cassettes ...Cassette) Cassettes {
+	counts := make(map[Amount]int, len(cassettes))
+	for _, cassette := range cassettes {
+		if cassette.Denomination.GreaterThan(ZeroAmount) && cassette.Count > 0 {
+			counts[cassette.Denomination] += cassette.Count
+		}
+	}
+	result := make(Cassettes, 0, len(counts))
+	for denomination, count := range counts {
+		result = append(result, Cassette{Denomination: denomination, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Denomination, result[j].Denomination
+		if a.currency != b.currency {
+			return a.currency < b.currency
+		}
+		return a.GreaterThan(b)
+	})
+	return result
+}
+
+// The value of the notes, which must all be in one currency.
+func (c Cassettes) Total() Amount {
+	total := ZeroAmount
+	for _, cassette := range c {
+		total = total.Add(cassette.Total())
+	}
+	return total
+}
+
+func (c Cassettes) Notes() int {
+	notes := 0
+	for _, cassette := range c {
+		notes += cassette.Count
+	}
+	return notes
+}
+
+// The smallest amount that withdrawals must be a multiple of, given the denominations currently
+// loaded. Returns ZeroAmount if there are no notes left.
+func (c Cassettes) Unit() Amount {
+	unit := ZeroAmount
+	for _, cassette := range c {
+		if cassette.Count > 0 {
+			unit = MinorAmount(cassette.Denomination.currency, gcd(unit.minor, cassette.Denomination.minor))
+		}
+	}
+	return unit
+}
+
+// The currency of the notes, or "" if there are none. Cassettes hold notes of one currency.
+func (c Cassettes) Currency() Currency {
+	for _, cassette := range c {
+		if cassette.Count > 0 {
+			return cassette.Denomination.currency
+		}
+	}
+	return ""
+}
+
+// The notes in the given currency.
+func (c Cassettes) In(currency Currency) Cassettes {
+	var result Cassettes
+	for _, cassette := range c {
+		if cassette.Denomination.currency == currency {
+			result = append(result, cassette)
+		}
+	}
+	return result
+}
+
+// Pick a mix of notes that adds up to exactly the given amount, preferring larger denominations.
+// Returns false if the loaded notes can't make up the amount, including when it's in another
+// currency.
+func (c Cassettes) Dispense(amount Amount) (Cassettes, bool) {
+	if !amount.GreaterThan(ZeroAmount) {
+		return nil, false
+	}
+	if currency := c.Currency(); currency != "" && currency != amount.currency {
+		return nil, false
+	}
+	sorted := NewCassettes(c...)
+	return dispense(sorted, 0, amount.minor, make(map[dispenseState]bool))
+}
+
+// Take the given notes out of the cassettes, returning what's left.
+func (c Cassettes) Remove(notes Cassettes) Cassettes {
+	removed := make(map[Amount]int, len(notes))
+	for _, note := range notes {
+		removed[note.Denomination] += note.Count
+	}
+	result := make(Cassettes, 0, len(c))
+	for _, cassette := range c {
+		count := cassette.Count - removed[cassette.Denomination]
+		if count < 0 {
+			count = 0
+		}
+		result = append(result, Cassette{Denomination: cassette.Denomination, Count: count})
+	}
+	return result
+}
+
+type dispenseState struct {
+	index     int
+	remaining int64
+}
+
+// Depth first search over the cassettes (sorted largest first), trying as many of each note as
+// possible before backing off. Dead ends are memoized so the search stays polynomial.
+func dispense(cassettes Cassettes, index int, remaining int64, failed map[dispenseState]bool) (Cassettes, bool) {
+	if remaining == 0 {
+		return Cassettes{}, true
+	}
+	if index == len(cassettes) {
+		return nil, false
+	}
+	state := dispenseState{index: index, remaining: remaining}
+	if failed[state] {
+		return nil, false
+	}
+	cassette := cassettes[index]
+	count := cassette.Count
+	if most := remaining / cassette.Denomination.minor; most < int64(count) {
+		count = int(most)
+	}
+	for n := count; n >= 0; n-- {
+		notes, ok := dispense(cassettes, index+1, remaining-int64(n)*cassette.Denomination.minor, failed)
+		if ok {
+			if n > 0 {
+				notes = append(Cassettes{{Denomination: cassette.Denomination, Count: n}}, notes...)
+			}
+			return notes, true
+		}
+	}
+	failed[state] = true
+	return nil, false
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,72 @@
+package pkg_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Cassettes", func() {
+	var (
+		cassettes pkg.Cassettes
+	)
+
+	BeforeEach(func() {
+		cassettes = pkg.NewCassettes(
+			pkg.Cassette{Denomination: pkg.Dollars(20), Count: 5},
+			pkg.Cassette{Denomination: pkg.Dollars(100), Count: 1},
+			pkg.Cassette{Denomination: pkg.Dollars(50), Count: 2},
+			pkg.Cassette{Denomination: pkg.Dollars(20), Count: 5},
+			pkg.Cassette{Denomination: pkg.Dollars(10), Count: 0},
+		)
+	})
+
+	It("merges and sorts denominations", func() {
+		Expect(cassettes).To(Equal(pkg.Cassettes{
+			{Denomination: pkg.Dollars(100), Count: 1},
+			{Denomination: pkg.Dollars(50), Count: 2},
+			{Denomination: pkg.Dollars(20), Count: 10},
+		}))
+		Expect(cassettes.Total()).To(Equal(pkg.Dollars(400)))
+		Expect(cassettes.Notes()).To(Equal(13))
+		Expect(cassettes.Unit()).To(Equal(pkg.Dollars(10)))
+	})
+
+	It("prefers larger notes", func() {
+		notes, ok := cassettes.Dispense(pkg.Dollars(170))
+		Expect(ok).To(BeTrue())
+		Expect(notes).To(Equal(pkg.Cassettes{
+			{Denomination: pkg.Dollars(100), Count: 1},
+			{Denomination: pkg.Dollars(50), Count: 1},
+			{Denomination: pkg.Dollars(20), Count: 1},
+		}))
+	})
+
+	It("backs off when the greedy choice doesn't work out", func() {
+		notes, ok := cassettes.Dispense(pkg.Dollars(160))
+		Expect(ok).To(BeTrue())
+		Expect(notes).To(Equal(pkg.Cassettes{
+			{Denomination: pkg.Dollars(100), Count: 1},
+			{Denomination: pkg.Dollars(20), Count: 3},
+		}))
+	})
+
+	It("fails when the notes can't make up the amount", func() {
+		_, ok := cassettes.Dispense(pkg.Dollars(30))
+		Expect(ok).To(BeFalse())
+		_, ok = cassettes.Dispense(pkg.Dollars(410))
+		Expect(ok).To(BeFalse())
+		_, ok = cassettes.Dispense(pkg.ZeroAmount)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("removes dispensed notes", func() {
+		notes, ok := cassettes.Dispense(pkg.Dollars(380))
+		Expect(ok).To(BeTrue())
+		remaining := cassettes.Remove(notes)
+		Expect(remaining.Total()).To(Equal(pkg.Dollars(20)))
+		Expect(remaining.Unit()).To(Equal(pkg.Dollars(20)))
+		remaining = remaining.Remove(pkg.Cassettes{{Denomination: pkg.Dollars(20), Count: 1}})
+		Expect(remaining.Unit()).To(Equal(pkg.ZeroAmount))
+	})
+})
@@ -0,0 +1,329 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Language string
+
+const (
+	English Language = "en"
+	Spanish Language = "es"
+)
+
+// The CLDR plural categories a count can fall into. Languages use only the ones they need, and every
+// plural message has an "other" form.
+type PluralCategory string
+
+const (
+	PluralZero  PluralCategory = "zero"
+	PluralOne   PluralCategory = "one"
+	PluralTwo   PluralCategory = "two"
+	PluralFew   PluralCategory = "few"
+	PluralMany  PluralCategory = "many"
+	PluralOther PluralCategory = "other"
+)
+
+var (
+	UnknownLanguageError = NewATMError("unknown_language", InputCategory, "Unknown language.")
+
+	// The catalogs the language command can switch to, by language. Add a site's own catalogs
+	// before creating any interfaces.
+	Catalogs = map[Language]*Catalog{
+		English: EnglishCatalog,
+		Spanish: SpanishCatalog,
+	}
+)
+
+// Everything a customer reads at the text interface, in one language. Messages are fmt format
+// strings, keyed by a stable id such as "balance". Plural messages have one entry per plural
+// category, such as "timeout_warning.one" and "timeout_warning.other". Errors are looked up by
+// code, such as "error.no_money", and keep their own message if the catalog has none. Anything
+// missing from a catalog is taken from EnglishCatalog.
+type Catalog struct {
+	Language Language
+	// The language's name in the language itself, such as "Español".
+	Name string
+	// How amounts are written, such as "$1,234.50" or "1.234,50 $".
+	Amounts AmountFormat
+	// Which plural category a count falls into.
+	Plural   func(n int) PluralCategory
+	Messages map[string]string
+}
+
+// The catalog's message, formatted with the given arguments.
+func (c *Catalog) Text(key string, args ...interface{}) string {
+	format, ok := c.lookup(key)
+	if !ok {
+		return key
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// The form of the catalog's message for the count n, formatted with the given arguments.
+func (c *Catalog) PluralText(key string, n int, args ...interface{}) string {
+	category := PluralOther
+	if c.Plural != nil {
+		category = c.Plural(n)
+	}
+	if format, ok := c.lookup(key + "." + string(category)); ok {
+		return fmt.Sprintf(format, args...)
+	}
+	return c.Text(key+"."+string(PluralOther), args...)
+}
+
+func (c *Catalog) lookup(key string) (string, bool) {
+	if format, ok := c.Messages[key]; ok {
+		return format, true
+	}
+	if c != EnglishCatalog {
+		return EnglishCatalog.lookup(key)
+	}
+	return "", false
+}
+
+// The amount in the catalog's format.
+func (c *Catalog) FormatAmount(amount Amount) string {
+	return amount.Format(c.Amounts)
+}
+
+// The customer-facing message for an error with the given code, or message if the catalog doesn't
+// have one. Errors whose message depends on what the customer entered, such as usage errors, are
+// worded by the catalog when they're made, so catalogs leave them out.
+func (c *Catalog) ErrorMessage(code, message string) string {
+	if format, ok := c.Messages["error."+code]; ok {
+		return format
+	}
+	return message
+}
+
+func (c *Catalog) AuthorizedMessage(id string) string {
+	return c.Text("authorized", id)
+}
+
+func (c *Catalog) BalanceMessage(amount Amount) string {
+	return c.Text("balance", c.FormatAmount(amount))
+}
+
+func (c *Catalog) WithdrawMessage(desiredAmt Amount, txn *Transaction) string {
+	msg := ""
+	dispensed := txn.CashDispensed()
+	if desiredAmt.GreaterThan(dispensed) {
+		msg += c.Text("partial_dispense")
+	}
+	msg += c.Text("dispensed", c.FormatAmount(dispensed))
+	if conversion := txn.Conversion; conversion != nil {
+		msg += c.Text("exchange", conversion.Rate.From, c.formatRate(conversion.Rate), conversion.Rate.To,
+			c.FormatAmount(txn.NetAmount().Abs()))
+	}
+	for _, fee := range txn.Fees {
+		if !txn.Refunded(fee) {
+			msg += c.Text("fee_charged", c.FormatAmount(fee.Amount.Abs()), c.memo(fee.Memo))
+		}
+	}
+	msg += c.BalanceMessage(txn.ClosingBalance())
+	return msg
+}
+
+// The rate with the catalog's decimal separator, such as "1,0873".
+func (c *Catalog) formatRate(rate Rate) string {
+	return c.decimal(rate.String())
+}
+
+// A plain decimal such as "1.0873" with the catalog's decimal separator.
+func (c *Catalog) decimal(value string) string {
+	if c.Amounts.DecimalSeparator == "" {
+		return value
+	}
+	return strings.Replace(value, ".", c.Amounts.DecimalSeparator, 1)
+}
+
+func (c *Catalog) HistoryMessage(history []Transaction) string {
+	msg := ""
+	for i := len(history) - 1; i >= 0; i-- {
+		msg += c.historyEntry(history[i])
+		if i > 0 {
+			msg += "\n"
+		}
+	}
+	return msg
+}
+
+// One line of the history, laid out as Transaction.Format but with the type, memo and labels
+// taken from the catalog.
+func (c *Catalog) historyEntry(t Transaction) string {
+	fields := []string{
+		t.Date.Format("2006-01-02 15:04:05"),
+		t.Id,
+		c.transactionType(t.Type),
+		c.FormatAmount(t.Amount),
+		c.FormatAmount(t.Balance),
+	}
+	if t.TerminalId != "" {
+		fields = append(fields, c.Text("history.terminal", t.TerminalId))
+	}
+	if t.ParentId != "" {
+		fields = append(fields, c.Text("history.parent", t.ParentId))
+	}
+	if t.Memo != "" {
+		fields = append(fields, c.Text("history.memo", c.memo(t.Memo)))
+	}
+	if t.Conversion != nil {
+		fields = append(fields,
+			c.Text("history.foreign", t.Conversion.Foreign.Currency(), c.decimal(t.Conversion.Foreign.String())),
+			c.Text("history.rate", c.formatRate(t.Conversion.Rate)))
+	}
+	return strings.Join(fields, " ")
+}
+
+// A transaction type such as "withdrawal", translated if the catalog knows it.
+func (c *Catalog) transactionType(transactionType TransactionType) string {
+	if translated, ok := c.Messages["transaction."+string(transactionType)]; ok {
+		return translated
+	}
+	return string(transactionType)
+}
+
+// A transaction memo such as "overdraft fee", translated if the catalog knows it.
+func (c *Catalog) memo(memo string) string {
+	if translated, ok := c.Messages["memo."+memo]; ok {
+		return translated
+	}
+	return memo
+}
+
+func (c *Catalog) LogoutMessage(accountId string) string {
+	return c.Text("logged_out", accountId)
+}
+
+// The remaining time is rounded up to whole seconds.
+func (c *Catalog) TimeoutWarningMessage(remaining time.Duration) string {
+	seconds := int((remaining + time.Second - 1) / time.Second)
+	return c.PluralText("timeout_warning", seconds, seconds)
+}
+
+// What the language command says about the catalog's language and the others available.
+func (c *Catalog) LanguageMessage() string {
+	return c.Text("language", c.Name, availableLanguages())
+}
+
+func (c *Catalog) unknownLanguageError(language string) error {
+	return UnknownLanguageError.WithMessage(c.Text("unknown_language", language, availableLanguages()))
+}
+
+// "en (English), es (Español)".
+func availableLanguages() string {
+	languages := make([]string, 0, len(Catalogs))
+	for language, catalog := range Catalogs {
+		languages = append(languages, fmt.Sprintf("%s (%s)", language, catalog.Name))
+	}
+	sort.Strings(languages)
+	return strings.Join(languages, ", ")
+}
+
+// The catalog for a language code such as "es", ignoring case.
+func LookupCatalog(language string) (*Catalog, bool) {
+	catalog, ok := Catalogs[Language(strings.ToLower(language))]
+	return catalog, ok
+}
+
+// "one", "two", and so on, or the number itself if the catalog has no word for it.
+func (c *Catalog) count(n int) string {
+	if word, ok := c.lookup(fmt.Sprintf("count.%d", n)); ok {
+		return word
+	}
+	return fmt.Sprint(n)
+}
+
+// "a", "a or b", or "a, b, or c".
+func (c *Catalog) orList(words []string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	case 2:
+		return c.Text("or_list.two", words[0], words[1])
+	}
+	return c.Text("or_list.many", strings.Join(words[:len(words)-1], ", "), words[len(words)-1])
+}
+
+// How to run the command, such as "Withdraw command requires one argument: <value>".
+func (c *Catalog) Usage(command Command) string {
+	name, title := command.Name, capitalize(command.Name)
+	args := strings.Join(command.argNames(), " ")
+	required := c.PluralText("arguments", len(command.Args), c.count(len(command.Args)))
+	optional := c.PluralText("arguments", len(command.OptionalArgs), c.count(len(command.OptionalArgs)))
+	switch {
+	case len(command.Args) == 0 && len(command.OptionalArgs) == 0:
+		return c.Text("usage.none", name, title)
+	case len(command.OptionalArgs) == 0:
+		return c.Text("usage.required", name, title, required, args)
+	case len(command.Args) == 0:
+		return c.Text("usage.optional", name, title, optional, args)
+	}
+	return c.Text("usage.both", name, title, required, args, c.count(len(command.OptionalArgs)), optional)
+}
+
+// What to tell a customer who didn't enter a command, listing every command.
+func (c *Catalog) CommandList(commands []Command) string {
+	names := make([]string, 0, len(commands))
+	for _, command := range commands {
+		names = append(names, command.Name)
+	}
+	if len(names) == 0 {
+		return c.Text("help.empty")
+	}
+	return c.Text("help", c.orList(names))
+}
+
+// The command's description, from the catalog if it has one.
+func (c *Catalog) CommandHelp(command Command) string {
+	if help, ok := c.Messages["command."+command.Name]; ok {
+		return help
+	}
+	return command.Help
+}
+
+// Every command's synopsis and description, for "help".
+func (c *Catalog) Overview(commands []Command) string {
+	width := 0
+	for _, command := range commands {
+		width = maxInt(width, len(command.Synopsis()))
+	}
+	lines := []string{c.Text("overview.header")}
+	for _, command := range commands {
+		lines = append(lines, fmt.Sprintf("  %-*s  %s", width, command.Synopsis(), c.CommandHelp(command)))
+	}
+	lines = append(lines, c.Text("overview.footer"))
+	return strings.Join(lines, "\n")
+}
+
+// The command's synopsis, description, aliases and examples, for "help <command>".
+func (c *Catalog) Detail(command Command) string {
+	lines := []string{command.Synopsis()}
+	if help := c.CommandHelp(command); help != "" {
+		lines = append(lines, help)
+	}
+	if len(command.Aliases) > 0 {
+		lines = append(lines, c.Text("detail.aliases", strings.Join(command.Aliases, ", ")))
+	}
+	if len(command.Examples) > 0 {
+		lines = append(lines, c.Text("detail.examples"))
+		for _, example := range command.Examples {
+			lines = append(lines, "  "+example)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// One for exactly one, other for everything else, as in English and Spanish.
+func oneOtherPlural(n int) PluralCategory {
+	if n == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
@@ -0,0 +1,60 @@
+package pkg
+
+// The messages every other catalog falls back to.
+var EnglishCatalog = &Catalog{
+	Language: English,
+	Name:     "English",
+	Amounts: AmountFormat{
+		CurrencySymbol:   true,
+		DecimalSeparator: ".",
+		GroupSeparator:   ",",
+	},
+	Plural: oneOtherPlural,
+	Messages: map[string]string{
+		"authorized":            "%s successfully authorized.",
+		"balance":               "Current balance: %s",
+		"partial_dispense":      "Unable to dispense full amount requested at this time. ",
+		"dispensed":             "Amount dispensed: %s\n",
+		"exchange":              "Exchange rate: 1 %s = %s %s. Your account has been charged %s.\n",
+		"fee_charged":           "You have been charged a %s %s. ",
+		"logged_out":            "Account %s logged out.",
+		"timeout_warning.one":   "Are you still there? You will be logged out in %d second unless you continue.",
+		"timeout_warning.other": "Are you still there? You will be logged out in %d seconds unless you continue.",
+		"language":              "Language: %s. Available: %s",
+		"unknown_language":      "Unknown language %s. Available: %s",
+
+		"history.terminal": "terminal=%s",
+		"history.parent":   "parent=%s",
+		"history.memo":     "memo=%q",
+		"history.foreign":  "foreign=%s%s",
+		"history.rate":     "rate=%s",
+
+		"help":              "Must provide command: %s",
+		"help.empty":        "Must provide command",
+		"unknown_command":   "Unknown command %s. Did you mean %s?",
+		"ambiguous_command": "%s could be %s.",
+		"usage.none":        "%[2]s command takes no arguments",
+		"usage.required":    "%[2]s command requires %[3]s: %[4]s",
+		"usage.optional":    "%[2]s command takes up to %[3]s: %[4]s",
+		"usage.both":        "%[2]s command requires %[3]s and takes up to %[5]s more: %[4]s",
+		"arguments.one":     "%s argument",
+		"arguments.other":   "%s arguments",
+		"overview.header":   "Commands:",
+		"overview.footer":   `Enter "help <command>" for more about a command. Commands can be shortened to any unique prefix, such as "w 40" or "bal".`,
+		"detail.aliases":    "Aliases: %s",
+		"detail.examples":   "Examples:",
+		"or_list.two":       "%s or %s",
+		"or_list.many":      "%s, or %s",
+
+		"count.0": "zero",
+		"count.1": "one",
+		"count.2": "two",
+		"count.3": "three",
+		"count.4": "four",
+		"count.5": "five",
+		"count.6": "six",
+		"count.7": "seven",
+		"count.8": "eight",
+		"count.9": "nine",
+	},
+}
@@ -0,0 +1,106 @@
+package pkg
+
+var SpanishCatalog = &Catalog{
+	Language: Spanish,
+	Name:     "Español",
+	Amounts: AmountFormat{
+		CurrencySymbol:   true,
+		SymbolAfter:      true,
+		SymbolSpace:      true,
+		DecimalSeparator: ",",
+		GroupSeparator:   ".",
+	},
+	Plural: oneOtherPlural,
+	Messages: map[string]string{
+		"authorized":            "Cuenta %s autorizada.",
+		"balance":               "Saldo actual: %s",
+		"partial_dispense":      "No es posible dispensar todo el importe solicitado en este momento. ",
+		"dispensed":             "Importe dispensado: %s\n",
+		"exchange":              "Tipo de cambio: 1 %s = %s %s. Se han cargado %s en su cuenta.\n",
+		"fee_charged":           "Se le ha cobrado %s de %s. ",
+		"logged_out":            "Sesión de la cuenta %s cerrada.",
+		"timeout_warning.one":   "¿Sigue ahí? Su sesión se cerrará en %d segundo si no continúa.",
+		"timeout_warning.other": "¿Sigue ahí? Su sesión se cerrará en %d segundos si no continúa.",
+		"language":              "Idioma: %s. Disponibles: %s",
+		"unknown_language":      "Idioma desconocido: %s. Disponibles: %s",
+
+		"history.terminal": "cajero=%s",
+		"history.parent":   "origen=%s",
+		"history.memo":     "concepto=%q",
+		"history.foreign":  "divisa=%s%s",
+		"history.rate":     "tipo=%s",
+
+		"help":              "Debe indicar un comando: %s",
+		"help.empty":        "Debe indicar un comando",
+		"unknown_command":   "Comando desconocido: %s. ¿Quiso decir %s?",
+		"ambiguous_command": "%s puede ser %s.",
+		"usage.none":        "El comando %[1]s no admite argumentos",
+		"usage.required":    "El comando %[1]s requiere %[3]s: %[4]s",
+		"usage.optional":    "El comando %[1]s admite hasta %[3]s: %[4]s",
+		"usage.both":        "El comando %[1]s requiere %[3]s y admite hasta %[6]s más: %[4]s",
+		"arguments.one":     "%s argumento",
+		"arguments.other":   "%s argumentos",
+		"overview.header":   "Comandos:",
+		"overview.footer":   `Escriba "help <comando>" para saber más de un comando. Los comandos se pueden abreviar a cualquier prefijo único, como "w 40" o "bal".`,
+		"detail.aliases":    "Alias: %s",
+		"detail.examples":   "Ejemplos:",
+		"or_list.two":       "%s o %s",
+		"or_list.many":      "%s o %s",
+
+		"count.0": "cero",
+		"count.1": "un",
+		"count.2": "dos",
+		"count.3": "tres",
+		"count.4": "cuatro",
+		"count.5": "cinco",
+		"count.6": "seis",
+		"count.7": "siete",
+		"count.8": "ocho",
+		"count.9": "nueve",
+
+		"command.authorize": "Inicia sesión en una cuenta, cerrando antes la actual.",
+		"command.withdraw":  "Retira efectivo de la cuenta. El cajero dispensa billetes enteros, así que el importe debe ser múltiplo del billete más pequeño. Añada un código de moneda, como EUR, para retirar divisas.",
+		"command.deposit":   "Ingresa dinero en la cuenta.",
+		"command.balance":   "Muestra el saldo de la cuenta.",
+		"command.history":   "Lista los movimientos de la cuenta, del más reciente al más antiguo.",
+		"command.logout":    "Cierra la sesión de la cuenta.",
+		"command.language":  "Muestra el idioma o cambia a otro.",
+		"command.help":      "Lista los comandos, o describe uno de ellos.",
+		"command.end":       "Cierra la sesión y sale del cajero.",
+
+		"transaction.withdrawal": "retirada",
+		"transaction.deposit":    "ingreso",
+		"transaction.fee":        "comisión",
+		"transaction.transfer":   "transferencia",
+		"transaction.reversal":   "anulación",
+		"transaction.interest":   "intereses",
+
+		"memo.overdraft fee":    "comisión por descubierto",
+		"memo.undispensed cash": "efectivo no dispensado",
+		"memo.fee refund":       "devolución de comisión",
+
+		"error.account_overdrawn":          "Su cuenta está en descubierto. No puede retirar efectivo en este momento.",
+		"error.insufficient_funds":         "Fondos insuficientes para este retiro.",
+		"error.overdraft_limit":            "Este retiro superaría su límite de descubierto.",
+		"error.amount_parse_error":         "El importe no es válido.",
+		"error.amount_out_of_range":        "El importe es demasiado grande.",
+		"error.unknown_currency":           "Moneda desconocida.",
+		"error.currency_mismatch":          "El importe está en una moneda distinta a la de su cuenta.",
+		"error.rate_unavailable":           "Esa moneda no está disponible en este momento.",
+		"error.rate_parse_error":           "El tipo de cambio no es válido.",
+		"error.factor_parse_error":         "El tipo no es válido.",
+		"error.unsupported_schema_version": "Los datos están en un formato no compatible.",
+		"error.authorization_failed":       "La autorización ha fallado.",
+		"error.authorization_required":     "Se requiere autorización.",
+		"error.not_authorized":             "No hay ninguna cuenta autorizada.",
+		"error.account_in_use":             "Esta cuenta ya tiene una sesión abierta.",
+		"error.invalid_amount":             "Importe no válido.",
+		"error.no_money":                   "No es posible procesar su retiro en este momento.",
+		"error.dispense_failed":            "No es posible dispensar efectivo en este momento. No se ha realizado ningún cargo en su cuenta.",
+		"error.atm_closed":                 "Este cajero está cerrado.",
+		"error.account_not_found":          "Cuenta no encontrada.",
+		"error.internal":                   "Error interno.",
+		"error.cancelled":                  "La solicitud se ha cancelado.",
+		"error.timeout":                    "La solicitud ha caducado.",
+	},
+}
@@ -0,0 +1,128 @@
+package pkg_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Catalog", func() {
+
+	const (
+		id  = "12345"
+		pin = "1234"
+	)
+
+	var (
+		atm     pkg.Atm
+		clock   *pkg.ManualClock
+		ui      pkg.TextInterface
+		notices chan string
+
+		ctx = context.Background()
+	)
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account := pkg.NewAccount(clock, id, pin, pkg.NewAmount(1234, 50), pkg.DefaultOverdraftPolicy)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 30,
+			pkg.NewCassetteDispenser(pkg.Cassette{Denomination: pkg.Dollars(20), Count: 10}), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		notices = make(chan string, 10)
+		ui = pkg.NewInterface(atm, pkg.TextFormat, func(message string) { notices <- message })
+	})
+
+	AfterEach(func() {
+		Expect(ui.Close()).To(Succeed())
+		Expect(atm.Close()).To(Succeed())
+	})
+
+	It("formats amounts the catalog's way", func() {
+		Expect(pkg.EnglishCatalog.FormatAmount(pkg.NewAmount(1234567, 5))).To(Equal("$1,234,567.05"))
+		Expect(pkg.SpanishCatalog.FormatAmount(pkg.NewAmount(1234567, 5))).To(Equal("1.234.567,05 $"))
+		Expect(pkg.SpanishCatalog.FormatAmount(pkg.NewAmount(-1234, -50))).To(Equal("-1.234,50 $"))
+	})
+
+	It("picks the plural form for a count", func() {
+		Expect(pkg.EnglishCatalog.TimeoutWarningMessage(time.Second)).To(
+			Equal("Are you still there? You will be logged out in 1 second unless you continue."))
+		Expect(pkg.EnglishCatalog.TimeoutWarningMessage(1500 * time.Millisecond)).To(
+			Equal("Are you still there? You will be logged out in 2 seconds unless you continue."))
+		Expect(pkg.SpanishCatalog.TimeoutWarningMessage(30 * time.Second)).To(
+			Equal("¿Sigue ahí? Su sesión se cerrará en 30 segundos si no continúa."))
+	})
+
+	It("falls back to English", func() {
+		catalog := &pkg.Catalog{
+			Language: "pirate",
+			Name:     "Pirate",
+			Messages: map[string]string{"balance": "Ye've got %s doubloons"},
+		}
+		Expect(catalog.BalanceMessage(pkg.Dollars(10))).To(Equal("Ye've got 10.00 doubloons"))
+		Expect(catalog.LogoutMessage(id)).To(Equal(pkg.LogoutMessage(id)))
+		Expect(catalog.ErrorMessage(pkg.NoMoneyError.Code, pkg.NoMoneyError.Message)).To(Equal(pkg.NoMoneyError.Message))
+	})
+
+	It("switches the session's language", func() {
+		Expect(ui.Execute(ctx, "language")).To(Equal("Language: English. Available: en (English), es (Español)"))
+		Expect(ui.Execute(ctx, "language ES")).To(Equal("Idioma: Español. Disponibles: en (English), es (Español)"))
+		Expect(ui.Execute(ctx, "authorize "+id+" "+pin)).To(Equal("Cuenta 12345 autorizada."))
+		Expect(ui.Execute(ctx, "balance")).To(Equal("Saldo actual: 1.234,50 $"))
+		Expect(ui.Execute(ctx, "withdraw 40")).To(Equal("Importe dispensado: 40,00 $\nSaldo actual: 1.194,50 $"))
+		Expect(ui.Execute(ctx, "language en")).To(Equal("Language: English. Available: en (English), es (Español)"))
+		Expect(ui.Execute(ctx, "balance")).To(Equal(pkg.BalanceMessage(pkg.NewAmount(1194, 50))))
+	})
+
+	It("words errors and usage in the session's language", func() {
+		Expect(ui.Execute(ctx, "language es")).To(HavePrefix("Idioma"))
+		response := ui.Respond(ctx, "balance")
+		Expect(response.ErrorCode).To(Equal(pkg.AuthorizationRequiredError.Code))
+		Expect(response.Message).To(Equal("Se requiere autorización."))
+		Expect(ui.Execute(ctx, "authorize "+id)).To(Equal("El comando authorize requiere dos argumentos: <id> <pin>"))
+		Expect(ui.Execute(ctx, "blance")).To(Equal("Comando desconocido: blance. ¿Quiso decir balance?"))
+		both := pkg.Command{Name: "withdraw", Args: []string{"value"}, OptionalArgs: []string{"currency"}}
+		Expect(pkg.SpanishCatalog.Usage(both)).To(Equal("El comando withdraw requiere un argumento y admite hasta un argumento más: <value> [currency]"))
+		Expect(ui.Execute(ctx, "help history")).To(HavePrefix("history\nLista los movimientos de la cuenta"))
+
+		response = ui.Respond(ctx, "language fr")
+		Expect(response.ErrorCode).To(Equal(pkg.UnknownLanguageError.Code))
+		Expect(response.Message).To(Equal("Idioma desconocido: fr. Disponibles: en (English), es (Español)"))
+	})
+
+	It("words the history in the catalog's language", func() {
+		account := pkg.NewAccount(clock, "67890", pin, pkg.Dollars(10), pkg.DefaultOverdraftPolicy)
+		_, err := account.Transaction(pkg.Dollars(-40), pkg.TransactionDetails{TerminalId: "ATM-TEST"})
+		Expect(err).To(BeNil())
+		history := pkg.SpanishCatalog.HistoryMessage(account.History())
+		Expect(history).To(MatchRegexp(`comisión -5,00 \$ -35,00 \$ cajero=ATM-TEST origen=\w+ concepto="comisión por descubierto"\n`))
+		Expect(history).To(MatchRegexp(`retirada -40,00 \$ -30,00 \$ cajero=ATM-TEST$`))
+	})
+
+	It("words notices in the session's language", func() {
+		Expect(ui.Execute(ctx, "language es")).To(HavePrefix("Idioma"))
+		Expect(ui.Execute(ctx, "authorize "+id+" "+pin)).To(Equal("Cuenta 12345 autorizada."))
+		clock.Advance(90 * time.Second)
+		Eventually(notices).Should(Receive(Equal("¿Sigue ahí? Su sesión se cerrará en 30 segundos si no continúa.")))
+		clock.Advance(30 * time.Second)
+		Eventually(notices).Should(Receive(Equal("Sesión de la cuenta 12345 cerrada.")))
+	})
+
+	It("takes and shows amounts in the account's currency", func() {
+		account := pkg.NewAccount(clock, "67890", pin, pkg.MajorAmount(pkg.JPY, 50000), pkg.OverdraftPolicy{})
+		yenAtm, err := pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 30,
+			pkg.NewCassetteDispenser(pkg.Cassette{Denomination: pkg.MajorAmount(pkg.JPY, 1000), Count: 10}), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		defer yenAtm.Close()
+		yenUi := pkg.NewInterface(yenAtm, pkg.TextFormat, nil)
+		defer yenUi.Close()
+
+		Expect(yenUi.Execute(ctx, "authorize 67890 "+pin)).To(Equal(pkg.AuthorizedMessage("67890")))
+		Expect(yenUi.Execute(ctx, "withdraw 3000")).To(Equal("Amount dispensed: ¥3,000\nCurrent balance: ¥47,000"))
+		response := yenUi.Respond(ctx, "withdraw 3000.50")
+		Expect(response.ErrorCode).To(Equal(pkg.AmountParseError.Code))
+	})
+})
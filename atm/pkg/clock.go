@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	SystemClock Clock = systemClock{}
+
+	_ Clock = new(ManualClock)
+)
+
+// A source of the current time. Everything time based in the package goes through a clock so tests
+// can control time rather than sleep.
+type Clock interface {
+	Now() time.Time
+	// Call f once the duration has passed. Like time.AfterFunc, f may be called on any goroutine.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	// Stop the timer from firing. Returns false if it has already fired or been stopped.
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// A clock that only moves when told to. Timers created from it fire as Advance or Set moves the
+// clock past them, on the goroutine that moved the clock, so they've all run by the time Advance
+// or Set returns.
+type ManualClock struct {
+	now    time.Time
+	timers []*manualTimer
+	mutex  *sync.Mutex
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{
+		now:   now,
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &manualTimer{
+		deadline: c.now.Add(d),
+		f:        f,
+		clock:    c,
+	}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Move the clock forward by the given duration, firing any timers along the way.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Move the clock to the given time, firing any timers along the way. Timers fire in deadline order
+// with the clock showing their deadline, so a timer started by another timer fires too if its
+// deadline is passed. Moving backwards doesn't fire anything.
+func (c *ManualClock) Set(now time.Time) {
+	for {
+		c.mutex.Lock()
+		timer := c.nextTimer(now)
+		if timer == nil {
+			c.now = now
+			c.mutex.Unlock()
+			return
+		}
+		if timer.deadline.After(c.now) {
+			c.now = timer.deadline
+		}
+		c.mutex.Unlock()
+		timer.f()
+	}
+}
+
+// Remove and return the earliest timer due by the given time, if any. Must be called with the
+// mutex held.
+func (c *ManualClock) nextTimer(now time.Time) *manualTimer {
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	if len(c.timers) == 0 || c.timers[0].deadline.After(now) {
+		return nil
+	}
+	timer := c.timers[0]
+	c.timers = c.timers[1:]
+	return timer
+}
+
+func (c *ManualClock) removeTimer(timer *manualTimer) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, t := range c.timers {
+		if t == timer {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	deadline time.Time
+	f        func()
+	clock    *ManualClock
+}
+
+func (t *manualTimer) Stop() bool {
+	return t.clock.removeTimer(t)
+}
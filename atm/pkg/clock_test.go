@@ -0,0 +1,65 @@
+package pkg_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("ManualClock", func() {
+	var (
+		start = time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC)
+		clock *pkg.ManualClock
+	)
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(start)
+	})
+
+	It("only moves when advanced", func() {
+		Expect(clock.Now()).To(Equal(start))
+		clock.Advance(time.Minute)
+		Expect(clock.Now()).To(Equal(start.Add(time.Minute)))
+		clock.Set(start)
+		Expect(clock.Now()).To(Equal(start))
+	})
+
+	It("fires timers as time passes", func() {
+		fired := []time.Time{}
+		clock.AfterFunc(time.Second, func() { fired = append(fired, clock.Now()) })
+		clock.Advance(999 * time.Millisecond)
+		Expect(fired).To(BeEmpty())
+		clock.Advance(time.Millisecond)
+		Expect(fired).To(Equal([]time.Time{start.Add(time.Second)}))
+		clock.Advance(time.Minute)
+		Expect(fired).To(HaveLen(1))
+	})
+
+	It("fires timers in order at their deadlines", func() {
+		fired := []time.Time{}
+		record := func() { fired = append(fired, clock.Now()) }
+		clock.AfterFunc(2*time.Second, record)
+		clock.AfterFunc(time.Second, func() {
+			record()
+			clock.AfterFunc(500*time.Millisecond, record)
+		})
+		clock.Advance(time.Minute)
+		Expect(fired).To(Equal([]time.Time{
+			start.Add(time.Second),
+			start.Add(1500 * time.Millisecond),
+			start.Add(2 * time.Second),
+		}))
+		Expect(clock.Now()).To(Equal(start.Add(time.Minute)))
+	})
+
+	It("stops timers", func() {
+		fired := false
+		timer := clock.AfterFunc(time.Second, func() { fired = true })
+		Expect(timer.Stop()).To(BeTrue())
+		Expect(timer.Stop()).To(BeFalse())
+		clock.Advance(time.Second)
+		Expect(fired).To(BeFalse())
+	})
+})
@@ -0,0 +1,460 @@
+package pkg
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	DuplicateCommandError = NewATMError("duplicate_command", InternalCategory, "A command with that name is already registered.")
+
+	// The commands text interfaces understand unless they're given their own. Register site-specific
+	// commands here before creating any interfaces, or use NewDefaultCommands to keep them separate.
+	DefaultCommands = NewDefaultCommands()
+
+	// Usage for the built-in commands. Interfaces generate theirs from their own registry, so these
+	// don't mention commands registered later.
+	HelpMessage          = NewDefaultCommands().Help()
+	HelpAuthorizeMessage = builtinUsage("authorize")
+	HelpWithdrawMessage  = builtinUsage("withdraw")
+	HelpDepositMessage   = builtinUsage("deposit")
+)
+
+// Runs a command for a text interface. Commands are always given all their required arguments and
+// at most their optional ones.
+type CommandHandler func(ctx context.Context, session *CommandSession, args []string) Response
+
+// What a command handler can see of the text interface running it.
+type CommandSession struct {
+	Atm Atm
+	// The commands the interface understands.
+	Commands *CommandRegistry
+	// The language the customer reads. Handlers that change language update it.
+	Catalog *Catalog
+	// The customer's session token, or "" if they aren't logged in. Handlers that log in or out
+	// update it, and the interface listens for timeouts on any new session.
+	Token string
+}
+
+// A command the text interface understands. Commands are matched ignoring case, and can be entered
+// as any prefix of their name or an alias that no other command shares.
+type Command struct {
+	Name string
+	// Other names the command can be run by.
+	Aliases []string
+	// The names of the arguments the command requires, in order, such as "id" and "pin".
+	Args []string
+	// The names of the arguments that may follow the required ones.
+	OptionalArgs []string
+	// A sentence describing what the command does.
+	Help string
+	// Sample uses of the command, shown by "help <command>".
+	Examples []string
+	Handler  CommandHandler
+}
+
+// The command's name followed by its arguments, such as "withdraw <value>" or "help [command]".
+func (c Command) Synopsis() string {
+	return strings.Join(append([]string{c.Name}, c.argNames()...), " ")
+}
+
+func (c Command) argNames() []string {
+	args := make([]string, 0, len(c.Args)+len(c.OptionalArgs))
+	for _, arg := range c.Args {
+		args = append(args, "<"+arg+">")
+	}
+	for _, arg := range c.OptionalArgs {
+		args = append(args, "["+arg+"]")
+	}
+	return args
+}
+
+// How to run the command in English, such as "Withdraw command requires one argument: <value>".
+func (c Command) Usage() string {
+	return EnglishCatalog.Usage(c)
+}
+
+func (c Command) accepts(args []string) bool {
+	return len(args) >= len(c.Args) && len(args) <= len(c.Args)+len(c.OptionalArgs)
+}
+
+// The commands a text interface understands, by name and alias. Safe to use from several
+// goroutines, so commands can be registered while interfaces are running.
+type CommandRegistry struct {
+	commands []Command
+	// Lower case names and aliases, to the index of their command.
+	byName map[string]int
+	mutex  *sync.RWMutex
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		byName: map[string]int{},
+		mutex:  &sync.RWMutex{},
+	}
+}
+
+// A new registry with the built-in commands: authorize, withdraw, deposit, balance, history,
+// logout, language, help and end.
+func NewDefaultCommands() *CommandRegistry {
+	registry := NewCommandRegistry()
+	for _, command := range builtinCommands() {
+		if err := registry.Register(command); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+func builtinCommands() []Command {
+	return []Command{
+		{
+			Name:     "authorize",
+			Args:     []string{"id", "pin"},
+			Help:     "Log in to an account, logging out of the current one first.",
+			Examples: []string{"authorize 12345 1234"},
+			Handler:  authorizeCommand,
+		},
+		{
+			Name:         "withdraw",
+			Args:         []string{"value"},
+			OptionalArgs: []string{"currency"},
+			Help:         "Withdraw cash from the account. The atm dispenses whole notes, so the value should be a multiple of the smallest one. Add a currency code, such as EUR, for foreign cash.",
+			Examples:     []string{"withdraw 40", "w 40", "withdraw 50 EUR"},
+			Handler:      withdrawCommand,
+		},
+		{
+			Name:     "deposit",
+			Args:     []string{"value"},
+			Help:     "Deposit money into the account.",
+			Examples: []string{"deposit 100", "deposit 12.50"},
+			Handler:  depositCommand,
+		},
+		{
+			Name:     "balance",
+			Help:     "Show the account's balance.",
+			Examples: []string{"balance", "bal"},
+			Handler:  balanceCommand,
+		},
+		{
+			Name:    "history",
+			Help:    "List the account's transactions, newest first.",
+			Handler: historyCommand,
+		},
+		{
+			Name:    "logout",
+			Help:    "Log out of the account.",
+			Handler: logoutCommand,
+		},
+		{
+			Name:         "language",
+			OptionalArgs: []string{"code"},
+			Help:         "Show the language, or switch to another.",
+			Examples:     []string{"language", "language es"},
+			Handler:      languageCommand,
+		},
+		{
+			Name:         "help",
+			OptionalArgs: []string{"command"},
+			Help:         "List the commands, or describe one of them.",
+			Examples:     []string{"help", "help withdraw"},
+			Handler:      helpCommand,
+		},
+		{
+			// The terminal stops once the end command succeeds.
+			Name:    EndCommand,
+			Help:    "Log out and leave the atm.",
+			Handler: endCommand,
+		},
+	}
+}
+
+func builtinUsage(name string) string {
+	for _, command := range builtinCommands() {
+		if command.Name == name {
+			return command.Usage()
+		}
+	}
+	return ""
+}
+
+// Add a command. Fails with DuplicateCommandError if its name or one of its aliases is taken,
+// ignoring case.
+func (r *CommandRegistry) Register(command Command) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	names := append([]string{command.Name}, command.Aliases...)
+	for _, name := range names {
+		if _, ok := r.byName[strings.ToLower(name)]; ok {
+			return DuplicateCommandError.WithDetail(name)
+		}
+	}
+	r.commands = append(r.commands, command)
+	for _, name := range names {
+		r.byName[strings.ToLower(name)] = len(r.commands) - 1
+	}
+	return nil
+}
+
+// The command with the given name or alias, ignoring case.
+func (r *CommandRegistry) Lookup(name string) (Command, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	i, ok := r.byName[strings.ToLower(name)]
+	if !ok {
+		return Command{}, false
+	}
+	return r.commands[i], true
+}
+
+// The command the customer meant: the one with the given name or alias, or else the only one with
+// a name or alias it's a prefix of. Fails with a UsageError saying which commands an ambiguous
+// prefix could be, suggesting the closest command to an unknown one, or listing every command if
+// none is close.
+func (r *CommandRegistry) Resolve(name string) (Command, error) {
+	return r.resolve(name, EnglishCatalog)
+}
+
+// Resolve, with any error worded by the catalog.
+func (r *CommandRegistry) resolve(name string, catalog *Catalog) (Command, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	key := strings.ToLower(name)
+	if i, ok := r.byName[key]; ok {
+		return r.commands[i], nil
+	}
+
+	var matches []int
+	for n, i := range r.byName {
+		if strings.HasPrefix(n, key) && !containsInt(matches, i) {
+			matches = append(matches, i)
+		}
+	}
+	sort.Ints(matches)
+	switch {
+	case len(matches) == 1:
+		return r.commands[matches[0]], nil
+	case len(matches) > 1:
+		names := make([]string, 0, len(matches))
+		for _, i := range matches {
+			names = append(names, r.commands[i].Name)
+		}
+		return Command{}, UsageError.WithMessage(catalog.Text("ambiguous_command", name, catalog.orList(names)))
+	}
+
+	if suggestion, ok := r.closest(key); ok {
+		return Command{}, UsageError.WithMessage(catalog.Text("unknown_command", name, suggestion))
+	}
+	return Command{}, UsageError.WithMessage(catalog.CommandList(r.commands))
+}
+
+// The furthest a mistyped command can be from the one suggested, in edits.
+const maxSuggestionDistance = 2
+
+// The command whose name or alias is the fewest edits from the given one, if it's close enough
+// that it was probably a typo. Must be called with the mutex held.
+func (r *CommandRegistry) closest(name string) (string, bool) {
+	best, bestDistance := -1, 0
+	for n, i := range r.byName {
+		distance := editDistance(name, n)
+		if distance > maxSuggestionDistance || distance > len(name)/2 {
+			continue
+		}
+		if best < 0 || distance < bestDistance || (distance == bestDistance && i < best) {
+			best, bestDistance = i, distance
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return r.commands[best].Name, true
+}
+
+// The Levenshtein distance between a and b: the fewest single character insertions, deletions and
+// substitutions that turn one into the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// The registered commands, in the order they were registered.
+func (r *CommandRegistry) Commands() []Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]Command(nil), r.commands...)
+}
+
+// What to tell a customer who didn't enter a command the registry knows, listing every command in
+// English.
+func (r *CommandRegistry) Help() string {
+	return EnglishCatalog.CommandList(r.Commands())
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// The word with its first letter upper-cased, such as "Withdraw".
+func capitalize(word string) string {
+	first, size := utf8.DecodeRuneInString(word)
+	if first == utf8.RuneError {
+		return word
+	}
+	return string(unicode.ToUpper(first)) + word[size:]
+}
+
+func authorizeCommand(ctx context.Context, session *CommandSession, args []string) Response {
+	if session.Token != "" {
+		_, _ = session.Atm.Logout(ctx, session.Token)
+		session.Token = ""
+	}
+	token, err := session.Atm.Authorize(ctx, args[0], args[1])
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	session.Token = token
+	return Response{
+		Status:    ResponseOk,
+		Message:   session.Catalog.AuthorizedMessage(args[0]),
+		AccountId: args[0],
+	}
+}
+
+func withdrawCommand(ctx context.Context, session *CommandSession, args []string) Response {
+	currency := ""
+	if len(args) > 1 {
+		currency = args[1]
+	}
+	amount, err := parseSessionAmount(ctx, session.Atm, session.Token, args[0], currency)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	txn, err := session.Atm.Withdraw(ctx, session.Token, amount)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	return newWithdrawResponse(session.Catalog, "", amount, txn)
+}
+
+func depositCommand(ctx context.Context, session *CommandSession, args []string) Response {
+	amount, err := parseSessionAmount(ctx, session.Atm, session.Token, args[0], "")
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	if err := session.Atm.Deposit(ctx, session.Token, amount); err != nil {
+		return NewErrorResponse("", err)
+	}
+	return balanceCommand(ctx, session, nil)
+}
+
+func balanceCommand(ctx context.Context, session *CommandSession, _ []string) Response {
+	balance, err := session.Atm.Balance(ctx, session.Token)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	return Response{
+		Status:  ResponseOk,
+		Message: session.Catalog.BalanceMessage(balance),
+		Balance: &balance,
+	}
+}
+
+func historyCommand(ctx context.Context, session *CommandSession, _ []string) Response {
+	history, err := session.Atm.History(ctx, session.Token)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	transactions := make([]Transaction, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		transactions = append(transactions, history[i])
+	}
+	return Response{
+		Status:       ResponseOk,
+		Message:      session.Catalog.HistoryMessage(history),
+		Transactions: transactions,
+	}
+}
+
+func logoutCommand(ctx context.Context, session *CommandSession, _ []string) Response {
+	accountId, err := session.Atm.Logout(ctx, session.Token)
+	session.Token = ""
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	return Response{
+		Status:    ResponseOk,
+		Message:   session.Catalog.LogoutMessage(accountId),
+		AccountId: accountId,
+	}
+}
+
+// Nothing is printed for the end command, so its response has no message.
+func endCommand(ctx context.Context, session *CommandSession, _ []string) Response {
+	if session.Token != "" {
+		_, _ = session.Atm.Logout(ctx, session.Token)
+		session.Token = ""
+	}
+	return Response{Status: ResponseOk}
+}
+
+func helpCommand(_ context.Context, session *CommandSession, args []string) Response {
+	if len(args) == 0 {
+		return Response{Status: ResponseOk, Message: session.Catalog.Overview(session.Commands.Commands())}
+	}
+	command, err := session.Commands.resolve(args[0], session.Catalog)
+	if err != nil {
+		return NewErrorResponse("", err)
+	}
+	return Response{Status: ResponseOk, Message: session.Catalog.Detail(command)}
+}
+
+// Reply in the new language, if there is one.
+func languageCommand(_ context.Context, session *CommandSession, args []string) Response {
+	if len(args) > 0 {
+		catalog, ok := LookupCatalog(args[0])
+		if !ok {
+			return NewErrorResponse("", session.Catalog.unknownLanguageError(args[0]))
+		}
+		session.Catalog = catalog
+	}
+	return Response{Status: ResponseOk, Message: session.Catalog.LanguageMessage()}
+}
@@ -0,0 +1,171 @@
+package pkg_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Commands", func() {
+
+	const (
+		id  = "12345"
+		pin = "1234"
+	)
+
+	var (
+		atm      pkg.Atm
+		commands *pkg.CommandRegistry
+		ui       pkg.TextInterface
+
+		ctx = context.Background()
+
+		// Says hello to whoever is logged in, or fails if no one is.
+		greet = pkg.Command{
+			Name:    "greet",
+			Aliases: []string{"hello"},
+			Args:    []string{"greeting"},
+			Help:    "Greet the customer.",
+			Handler: func(ctx context.Context, session *pkg.CommandSession, args []string) pkg.Response {
+				balance, err := session.Atm.Balance(ctx, session.Token)
+				if err != nil {
+					return pkg.NewErrorResponse("", err)
+				}
+				return pkg.Response{
+					Status:  pkg.ResponseOk,
+					Message: strings.Title(args[0]) + "! Your balance is " + balance.String(),
+				}
+			},
+		}
+	)
+
+	BeforeEach(func() {
+		clock := pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account := pkg.NewAccount(clock, id, pin, pkg.Dollars(100), pkg.DefaultOverdraftPolicy)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 0, pkg.NewCassetteDispenser(), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		commands = pkg.NewDefaultCommands()
+		ui = pkg.NewInterfaceWithCommands(atm, commands, pkg.TextFormat, nil)
+	})
+
+	AfterEach(func() {
+		Expect(ui.Close()).To(Succeed())
+		Expect(atm.Close()).To(Succeed())
+	})
+
+	It("generates help from the registered commands", func() {
+		Expect(commands.Help()).To(Equal(pkg.HelpMessage))
+		Expect(pkg.HelpMessage).To(Equal("Must provide command: authorize, withdraw, deposit, balance, history, logout, language, help, or end"))
+		Expect(pkg.HelpAuthorizeMessage).To(Equal("Authorize command requires two arguments: <id> <pin>"))
+		Expect(pkg.HelpWithdrawMessage).To(Equal("Withdraw command requires one argument and takes up to one more: <value> [currency]"))
+		Expect(pkg.HelpDepositMessage).To(Equal("Deposit command requires one argument: <value>"))
+
+		balance, ok := commands.Lookup("balance")
+		Expect(ok).To(BeTrue())
+		Expect(balance.Usage()).To(Equal("Balance command takes no arguments"))
+		Expect(pkg.Command{Name: "échange"}.Usage()).To(Equal("Échange command takes no arguments"))
+		help, ok := commands.Lookup("help")
+		Expect(ok).To(BeTrue())
+		Expect(help.Usage()).To(Equal("Help command takes up to one argument: [command]"))
+		Expect(help.Synopsis()).To(Equal("help [command]"))
+	})
+
+	It("matches commands ignoring case", func() {
+		Expect(ui.Execute(ctx, "AUTHORIZE "+id+" "+pin)).To(Equal(pkg.AuthorizedMessage(id)))
+		Expect(ui.Execute(ctx, "Balance")).To(Equal(pkg.BalanceMessage(pkg.Dollars(100))))
+	})
+
+	It("accepts unique prefixes", func() {
+		Expect(ui.Execute(ctx, "a "+id+" "+pin)).To(Equal(pkg.AuthorizedMessage(id)))
+		Expect(ui.Execute(ctx, "bal")).To(Equal(pkg.BalanceMessage(pkg.Dollars(100))))
+		Expect(ui.Execute(ctx, "dep 20")).To(Equal(pkg.BalanceMessage(pkg.Dollars(120))))
+		response := ui.Respond(ctx, "w 40")
+		Expect(response.Command).To(Equal("withdraw"))
+		Expect(response.ErrorCode).To(Equal(pkg.NoMoneyError.Code))
+	})
+
+	It("says what an ambiguous prefix could be", func() {
+		response := ui.Respond(ctx, "h")
+		Expect(response.ErrorCode).To(Equal(pkg.UsageError.Code))
+		Expect(response.Message).To(Equal("h could be history or help."))
+	})
+
+	It("suggests the closest command to a typo", func() {
+		Expect(ui.Execute(ctx, "withdrwa 40")).To(Equal("Unknown command withdrwa. Did you mean withdraw?"))
+		Expect(ui.Execute(ctx, "blance")).To(Equal("Unknown command blance. Did you mean balance?"))
+		Expect(ui.Execute(ctx, "xyzzy")).To(Equal(pkg.HelpMessage))
+		Expect(ui.Respond(ctx, "blance").ErrorCode).To(Equal(pkg.UsageError.Code))
+	})
+
+	It("lists every command", func() {
+		msg := ui.Execute(ctx, "help")
+		Expect(msg).To(HavePrefix("Commands:\n  authorize <id> <pin>         Log in to an account"))
+		Expect(msg).To(ContainSubstring("\n  withdraw <value> [currency]  Withdraw cash"))
+		Expect(msg).To(ContainSubstring("\n  help [command]               List the commands"))
+	})
+
+	It("describes a command", func() {
+		Expect(commands.Register(greet)).To(Succeed())
+		Expect(ui.Execute(ctx, "help hello")).To(Equal("greet <greeting>\nGreet the customer.\nAliases: hello"))
+		msg := ui.Execute(ctx, "help w")
+		Expect(msg).To(HavePrefix("withdraw <value> [currency]\nWithdraw cash from the account."))
+		Expect(msg).To(HaveSuffix("Examples:\n  withdraw 40\n  w 40\n  withdraw 50 EUR"))
+		Expect(ui.Execute(ctx, "help withdrwa")).To(Equal("Unknown command withdrwa. Did you mean withdraw?"))
+		Expect(ui.Execute(ctx, "help balance now")).To(Equal("Help command takes up to one argument: [command]"))
+	})
+
+	It("runs site-specific commands", func() {
+		Expect(commands.Register(greet)).To(Succeed())
+		Expect(ui.Execute(ctx, "foo")).To(Equal("Must provide command: authorize, withdraw, deposit, balance, history, logout, language, help, end, or greet"))
+
+		Expect(ui.Execute(ctx, "authorize "+id+" "+pin)).To(Equal(pkg.AuthorizedMessage(id)))
+		Expect(ui.Execute(ctx, "greet hi")).To(Equal("Hi! Your balance is 100.00"))
+		response := ui.Respond(ctx, "hello hey")
+		Expect(response.Command).To(Equal("greet"))
+		Expect(response.Message).To(Equal("Hey! Your balance is 100.00"))
+	})
+
+	It("checks the number of arguments", func() {
+		Expect(commands.Register(greet)).To(Succeed())
+		Expect(ui.Execute(ctx, "greet")).To(Equal("Greet command requires one argument: <greeting>"))
+		Expect(ui.Execute(ctx, "hello there you")).To(Equal("Greet command requires one argument: <greeting>"))
+		Expect(ui.Execute(ctx, "balance now")).To(Equal("Balance command takes no arguments"))
+	})
+
+	It("lets handlers see the session", func() {
+		Expect(commands.Register(greet)).To(Succeed())
+		response := ui.Respond(ctx, "greet hi")
+		Expect(response.ErrorCode).To(Equal(pkg.AuthorizationRequiredError.Code))
+	})
+
+	It("rejects duplicate names and aliases", func() {
+		err := commands.Register(pkg.Command{Name: "withdraw", Handler: greet.Handler})
+		Expect(errors.Is(err, pkg.DuplicateCommandError)).To(BeTrue())
+		Expect(commands.Register(greet)).To(Succeed())
+		err = commands.Register(pkg.Command{Name: "wave", Aliases: []string{"Hello"}, Handler: greet.Handler})
+		Expect(errors.Is(err, pkg.DuplicateCommandError)).To(BeTrue())
+		_, ok := commands.Lookup("wave")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("keeps registries separate", func() {
+		Expect(commands.Register(greet)).To(Succeed())
+		_, ok := pkg.DefaultCommands.Lookup("greet")
+		Expect(ok).To(BeFalse())
+		Expect(pkg.NewInterface(atm, pkg.TextFormat, nil).Execute(ctx, "greet hi")).To(Equal(pkg.HelpMessage))
+	})
+
+	It("logs out at the end command", func() {
+		Expect(ui.Execute(ctx, "authorize "+id+" "+pin)).To(Equal(pkg.AuthorizedMessage(id)))
+		response := ui.Respond(ctx, "end")
+		Expect(response.Command).To(Equal(pkg.EndCommand))
+		Expect(response.Status).To(Equal(pkg.ResponseOk))
+		Expect(ui.Respond(ctx, "balance").ErrorCode).To(Equal(pkg.AuthorizationRequiredError.Code))
+	})
+})
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"sync"
+)
+
+var (
+	_ CashDispenser = new(cassetteDispenser)
+)
+
+type DispenseStatus string
+
+const (
+	DispenseComplete DispenseStatus = "complete"
+	DispensePartial  DispenseStatus = "partial"
+	DispenseFailed   DispenseStatus = "failed"
+)
+
+// What a dispenser reports after trying to pay out notes.
+type DispenseResult struct {
+	Status DispenseStatus
+	// The notes that actually reached the customer.
+	Dispensed Cassettes
+}
+
+// The device that physically pays out cash.
+type CashDispenser interface {
+	// The notes currently available to dispense.
+	Cassettes() Cassettes
+	// Pay out the given notes. Notes that didn't reach the customer must not be reported as
+	// dispensed, as the customer's account is credited back for them.
+	Dispense(notes Cassettes) DispenseResult
+}
+
+// A dispenser that never jams: it pays out whatever it's asked to, as long as it has the notes.
+func NewCassetteDispenser(cassettes ...Cassette) CashDispenser {
+	return &cassetteDispenser{
+		cassettes: NewCassettes(cassettes...),
+		mutex:     &sync.Mutex{},
+	}
+}
+
+type cassetteDispenser struct {
+	cassettes Cassettes
+	mutex     *sync.Mutex
+}
+
+func (d *cassetteDispenser) Cassettes() Cassettes {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append(Cassettes(nil), d.cassettes...)
+}
+
+func (d *cassetteDispenser) Dispense(notes Cassettes) DispenseResult {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	available := make(map[Amount]int, len(d.cassettes))
+	for _, cassette := range d.cassettes {
+		available[cassette.Denomination] = cassette.Count
+	}
+	dispensed := Cassettes{}
+	for _, note := range notes {
+		count := note.Count
+		if count > available[note.Denomination] {
+			count = available[note.Denomination]
+		}
+		if count > 0 {
+			dispensed = append(dispensed, Cassette{Denomination: note.Denomination, Count: count})
+			available[note.Denomination] -= count
+		}
+	}
+	d.cassettes = d.cassettes.Remove(dispensed)
+	return NewDispenseResult(notes, dispensed)
+}
+
+// Work out the status of a dispense from the notes requested and the notes that came out.
+func NewDispenseResult(requested, dispensed Cassettes) DispenseResult {
+	status := DispensePartial
+	if dispensed.Total().IsZero() {
+		status = DispenseFailed
+	} else if dispensed.Total() == requested.Total() {
+		status = DispenseComplete
+	}
+	return DispenseResult{
+		Status:    status,
+		Dispensed: NewCassettes(dispensed...),
+	}
+}
@@ -0,0 +1,41 @@
+package pkg_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("CashDispenser", func() {
+	var (
+		dispenser pkg.CashDispenser
+	)
+
+	BeforeEach(func() {
+		dispenser = pkg.NewCassetteDispenser(
+			pkg.Cassette{Denomination: pkg.Dollars(50), Count: 1},
+			pkg.Cassette{Denomination: pkg.Dollars(20), Count: 2},
+		)
+	})
+
+	It("pays out notes", func() {
+		notes := pkg.Cassettes{{Denomination: pkg.Dollars(50), Count: 1}, {Denomination: pkg.Dollars(20), Count: 1}}
+		result := dispenser.Dispense(notes)
+		Expect(result.Status).To(Equal(pkg.DispenseComplete))
+		Expect(result.Dispensed).To(Equal(notes))
+		Expect(dispenser.Cassettes().Total()).To(Equal(pkg.Dollars(20)))
+	})
+
+	It("reports partial dispenses", func() {
+		result := dispenser.Dispense(pkg.Cassettes{{Denomination: pkg.Dollars(20), Count: 3}})
+		Expect(result.Status).To(Equal(pkg.DispensePartial))
+		Expect(result.Dispensed).To(Equal(pkg.Cassettes{{Denomination: pkg.Dollars(20), Count: 2}}))
+		Expect(dispenser.Cassettes().Total()).To(Equal(pkg.Dollars(50)))
+	})
+
+	It("reports failed dispenses", func() {
+		result := dispenser.Dispense(pkg.Cassettes{{Denomination: pkg.Dollars(100), Count: 1}})
+		Expect(result.Status).To(Equal(pkg.DispenseFailed))
+		Expect(result.Dispensed).To(BeEmpty())
+	})
+})
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// Something went wrong that the customer can't do anything about, such as a failed disk write.
+	InternalError = NewATMError("internal", InternalCategory, "Internal error.")
+	// The call's context was cancelled before it got going.
+	CancelledError = NewATMError("cancelled", InternalCategory, "The request was cancelled.")
+	// The call's context timed out before it got going.
+	TimeoutError = NewATMError("timeout", InternalCategory, "The request timed out.")
+	// A command was malformed. The message says how to use it.
+	UsageError = NewATMError("usage", InputCategory, "Invalid command.")
+
+	_ error = new(ATMError)
+)
+
+type ErrorCategory string
+
+const (
+	// The customer isn't, or can't be, logged in.
+	AuthCategory ErrorCategory = "auth"
+	// The account can't cover what was asked for.
+	FundsCategory ErrorCategory = "funds"
+	// The atm itself can't do what was asked for, such as when it's out of cash.
+	DeviceCategory ErrorCategory = "device"
+	// What the customer entered doesn't make sense.
+	InputCategory ErrorCategory = "input"
+	// Anything else.
+	InternalCategory ErrorCategory = "internal"
+)
+
+// An error with a stable code that front ends can rely on, however its message is worded. The
+// exported errors in the package are all ATMErrors, and an error matches one with errors.Is if it
+// has the same code, so errors derived from them with WithCause or WithDetail still match.
+type ATMError struct {
+	Code     string
+	Category ErrorCategory
+	// What the customer is told.
+	Message string
+	// What only the logs are told.
+	Detail string
+	Cause  error
+}
+
+func NewATMError(code string, category ErrorCategory, message string) *ATMError {
+	return &ATMError{
+		Code:     code,
+		Category: category,
+		Message:  message,
+	}
+}
+
+// The message, followed by the detail and cause if there are any. Use Message for what the
+// customer should see.
+func (e *ATMError) Error() string {
+	msg := e.Message
+	if e.Detail != "" {
+		msg += " " + e.Detail
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *ATMError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *ATMError) Is(target error) bool {
+	t, ok := target.(*ATMError)
+	return ok && t.Code == e.Code
+}
+
+// A copy of the error caused by another.
+func (e *ATMError) WithCause(cause error) *ATMError {
+	err := *e
+	err.Cause = cause
+	return &err
+}
+
+// A copy of the error with more detail for the logs.
+func (e *ATMError) WithDetail(detail string) *ATMError {
+	err := *e
+	err.Detail = detail
+	return &err
+}
+
+// A copy of the error with a different message for the customer.
+func (e *ATMError) WithMessage(message string) *ATMError {
+	err := *e
+	err.Message = message
+	return &err
+}
+
+// The error as an ATMError. Errors that aren't ATMErrors are reported as CancelledError or
+// TimeoutError if they come from a context, or InternalError otherwise, with the original error
+// as the cause.
+func AsATMError(err error) *ATMError {
+	var atmErr *ATMError
+	if errors.As(err, &atmErr) {
+		return atmErr
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CancelledError.WithCause(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return TimeoutError.WithCause(err)
+	}
+	return InternalError.WithCause(err)
+}
+
+// What the customer should be told about an error. Errors that aren't ATMErrors may have details
+// the customer shouldn't see, so they're only told something went wrong.
+func UserMessage(err error) string {
+	return AsATMError(err).Message
+}
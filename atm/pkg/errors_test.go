@@ -0,0 +1,58 @@
+package pkg_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("ATMError", func() {
+
+	It("matches errors with the same code", func() {
+		cause := errors.New("disk full")
+		err := fmt.Errorf("withdrawing: %w", pkg.DispenseFailedError.WithCause(cause).WithDetail("(cassette 2)"))
+		Expect(errors.Is(err, pkg.DispenseFailedError)).To(BeTrue())
+		Expect(errors.Is(err, pkg.NoMoneyError)).To(BeFalse())
+		Expect(errors.Is(err, cause)).To(BeTrue())
+		var atmErr *pkg.ATMError
+		Expect(errors.As(err, &atmErr)).To(BeTrue())
+		Expect(atmErr.Code).To(Equal("dispense_failed"))
+		Expect(atmErr.Category).To(Equal(pkg.DeviceCategory))
+	})
+
+	It("keeps details out of the customer's message", func() {
+		err := pkg.DispenseFailedError.WithCause(errors.New("disk full")).WithDetail("(cassette 2)")
+		Expect(err.Error()).To(Equal(pkg.DispenseFailedError.Message + " (cassette 2): disk full"))
+		Expect(pkg.UserMessage(err)).To(Equal(pkg.DispenseFailedError.Message))
+		Expect(pkg.DispenseFailedError.Error()).To(Equal(pkg.DispenseFailedError.Message))
+	})
+
+	It("doesn't change the original when deriving errors", func() {
+		_ = pkg.NoMoneyError.WithMessage("Out of cash.")
+		Expect(pkg.NoMoneyError.Message).To(Equal("Unable to process your withdrawal at this time."))
+	})
+
+	It("reports other errors as internal", func() {
+		cause := errors.New("disk full")
+		err := pkg.AsATMError(cause)
+		Expect(errors.Is(err, pkg.InternalError)).To(BeTrue())
+		Expect(err.Cause).To(Equal(cause))
+		Expect(pkg.UserMessage(cause)).To(Equal(pkg.InternalError.Message))
+		Expect(errors.Is(pkg.AsATMError(context.Canceled), pkg.CancelledError)).To(BeTrue())
+		Expect(errors.Is(pkg.AsATMError(fmt.Errorf("waiting: %w", context.DeadlineExceeded)), pkg.TimeoutError)).To(BeTrue())
+	})
+
+	It("can match amount parse errors", func() {
+		_, err := pkg.ParseAmount("1.234")
+		Expect(errors.Is(err, pkg.AmountParseError)).To(BeTrue())
+		Expect(err.Error()).To(Equal("Error parsing amount: too many digits in the cents"))
+		_, err = pkg.ParseAmount("x")
+		Expect(errors.Is(err, pkg.AmountParseError)).To(BeTrue())
+		Expect(pkg.UserMessage(err)).To(Equal("Error parsing amount: error parsing dollars"))
+		Expect(errors.Unwrap(err)).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+var (
+	RateUnavailableError = NewATMError("rate_unavailable", DeviceCategory, "That currency isn't available at this time.")
+	RateParseError       = NewATMError("rate_parse_error", InputCategory, "Error parsing exchange rate.")
+
+	_ RateProvider = new(staticRates)
+)
+
+// How much of one currency a unit of another is worth, such as 1.0873 dollars to the euro.
+type Rate struct {
+	From Currency
+	To   Currency
+	// The rate in units of 10^-RateDecimals.
+	scaled int64
+}
+
+// Parse a rate written as a positive decimal, such as "1.0873", with at most RateDecimals digits
+// after the decimal point.
+func ParseRate(from, to Currency, rate string) (Rate, error) {
+	scaled, ok := parseScaled(rate)
+	if !ok || scaled <= 0 {
+		return Rate{}, RateParseError.WithDetail(rate)
+	}
+	return Rate{From: from, To: to, scaled: scaled}, nil
+}
+
+// The rate written as a decimal without trailing zeros, such as "1.0873".
+func (r Rate) String() string {
+	return formatScaled(r.scaled)
+}
+
+// The rate the other way round, such as euros to the dollar rather than dollars to the euro.
+func (r Rate) Inverse() Rate {
+	scaled := RoundHalfUp.divide(big.NewInt(rateScale*rateScale), big.NewInt(r.scaled))
+	return Rate{From: r.To, To: r.From, scaled: scaled.Int64()}
+}
+
+// The rate marked up by the given number of basis points (hundredths of a percent).
+func (r Rate) WithSpread(basisPoints int) Rate {
+	n := new(big.Int).Mul(big.NewInt(r.scaled), big.NewInt(int64(10000+basisPoints)))
+	r.scaled = RoundHalfUp.divide(n, big.NewInt(10000)).Int64()
+	return r
+}
+
+// Convert an amount in the rate's From currency to its To currency, rounding to the To currency's
+// minor units. Like Add, panics with a CurrencyMismatchError if the amount is in another currency,
+// or an AmountRangeError if the result is out of range.
+func (r Rate) Convert(amount Amount, rounding RoundingMode) Amount {
+	converted, err := r.ConvertChecked(amount, rounding)
+	if err != nil {
+		panic(err)
+	}
+	return converted
+}
+
+// Convert, returning a CurrencyMismatchError or AmountRangeError rather than panicking.
+func (r Rate) ConvertChecked(amount Amount, rounding RoundingMode) (Amount, error) {
+	if !Zero(r.From).SameCurrency(amount) {
+		return ZeroAmount, currencyMismatchError(r.From, amount.currency)
+	}
+	n := big.NewInt(amount.minor)
+	n.Mul(n, big.NewInt(r.scaled))
+	n.Mul(n, big.NewInt(int64(r.To.minorPerMajor())))
+	d := big.NewInt(rateScale)
+	d.Mul(d, big.NewInt(int64(r.From.minorPerMajor())))
+	converted := rounding.divide(n, d)
+	if converted.CmpAbs(big.NewInt(MaxMinorUnits)) > 0 {
+		return ZeroAmount, amountRangeError(fmt.Sprintf("(%s at %s)", amount, r))
+	}
+	return Amount{minor: converted.Int64(), currency: r.To}, nil
+}
+
+// Where an atm gets its exchange rates.
+type RateProvider interface {
+	// The mid-market rate from one currency to another. Fails with RateUnavailableError if there
+	// isn't one.
+	Rate(ctx context.Context, from, to Currency) (Rate, error)
+}
+
+// How an atm sells foreign cash: at the provider's rate marked up by the spread, with the cost in
+// the account's currency rounded as given.
+type Exchange struct {
+	Rates RateProvider
+	// The bank's margin over the provider's rate, in basis points: 250 charges 2.5% more.
+	SpreadBasisPoints int
+	// Defaults to RoundHalfUp.
+	Rounding RoundingMode
+}
+
+// The rate a customer pays for the foreign currency in their home currency.
+func (e *Exchange) rate(ctx context.Context, foreign, home Currency) (Rate, error) {
+	rate, err := e.Rates.Rate(ctx, foreign, home)
+	if err != nil {
+		return Rate{}, err
+	}
+	return rate.WithSpread(e.SpreadBasisPoints), nil
+}
+
+func (e *Exchange) rounding() RoundingMode {
+	if e.Rounding == "" {
+		return RoundHalfUp
+	}
+	return e.Rounding
+}
+
+// Foreign cash dispensed by a withdrawal, what it cost in the account's currency, and the rate
+// that was used, spread included.
+type Conversion struct {
+	Foreign Amount
+	Home    Amount
+	Rate    Rate
+}
+
+// A provider with a fixed set of rates. Each rate also serves the other way round, as its inverse.
+func NewStaticRates(rates ...Rate) RateProvider {
+	provider := &staticRates{rates: make(map[[2]Currency]Rate, len(rates))}
+	for _, rate := range rates {
+		provider.rates[[2]Currency{rate.From, rate.To}] = rate
+	}
+	return provider
+}
+
+type staticRates struct {
+	rates map[[2]Currency]Rate
+}
+
+func (s *staticRates) Rate(ctx context.Context, from, to Currency) (Rate, error) {
+	if rate, ok := s.rates[[2]Currency{from, to}]; ok {
+		return rate, nil
+	}
+	if rate, ok := s.rates[[2]Currency{to, from}]; ok {
+		return rate.Inverse(), nil
+	}
+	return Rate{}, RateUnavailableError.WithDetail(fmt.Sprintf("(%s to %s)", from, to))
+}
+
+type rateRecord struct {
+	From Currency `json:"from"`
+	To   Currency `json:"to"`
+	Rate string   `json:"rate"`
+}
+
+// Load a static rate table from a JSON file listing rates such as
+// {"from": "EUR", "to": "USD", "rate": "1.0873"}.
+func OpenRateFile(path string) (RateProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []rateRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("reading rates from %s: %w", path, err)
+	}
+	rates := make([]Rate, 0, len(records))
+	for _, record := range records {
+		from, err := ParseCurrency(string(record.From))
+		if err != nil {
+			return nil, fmt.Errorf("reading rates from %s: %w", path, err)
+		}
+		to, err := ParseCurrency(string(record.To))
+		if err != nil {
+			return nil, fmt.Errorf("reading rates from %s: %w", path, err)
+		}
+		rate, err := ParseRate(from, to, record.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("reading rates from %s: %w", path, err)
+		}
+		rates = append(rates, rate)
+	}
+	return NewStaticRates(rates...), nil
+}
@@ -0,0 +1,216 @@
+package pkg_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Exchange", func() {
+
+	const (
+		id  = "12345"
+		pin = "1234"
+	)
+
+	var (
+		clock   *pkg.ManualClock
+		account pkg.Account
+		atm     pkg.Atm
+		token   string
+
+		ctx    = context.Background()
+		euros  = func(units int64) pkg.Amount { return pkg.MajorAmount(pkg.EUR, units) }
+		toEuro pkg.Rate
+	)
+
+	mustParseRate := func(from, to pkg.Currency, rate string) pkg.Rate {
+		parsed, err := pkg.ParseRate(from, to, rate)
+		Expect(err).To(BeNil())
+		return parsed
+	}
+
+	newAtm := func(dispenser pkg.CashDispenser, exchange *pkg.Exchange) {
+		var err error
+		atm, err = pkg.NewAtmWithExchange(ctx, clock, "ATM-TEST", 120, 30, dispenser, pkg.NewMemoryStore(account), nil, exchange)
+		Expect(err).To(BeNil())
+		token, err = atm.Authorize(ctx, id, pin)
+		Expect(err).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account = pkg.NewAccount(clock, id, pin, pkg.Dollars(1000), pkg.DefaultOverdraftPolicy)
+		toEuro = mustParseRate(pkg.EUR, pkg.USD, "1.0873")
+		newAtm(pkg.NewCassetteDispenser(
+			pkg.Cassette{Denomination: pkg.Dollars(20), Count: 10},
+			pkg.Cassette{Denomination: euros(50), Count: 10},
+			pkg.Cassette{Denomination: euros(20), Count: 10},
+		), &pkg.Exchange{Rates: pkg.NewStaticRates(toEuro), SpreadBasisPoints: 250})
+	})
+
+	AfterEach(func() {
+		Expect(atm.Close()).To(Succeed())
+	})
+
+	Context("rates", func() {
+		It("parses and writes decimals", func() {
+			Expect(toEuro.String()).To(Equal("1.0873"))
+			Expect(mustParseRate(pkg.USD, pkg.JPY, "150").String()).To(Equal("150"))
+			for _, bad := range []string{"", "0", "-1.2", "1.2.3", "1.123456789", "abc"} {
+				_, err := pkg.ParseRate(pkg.EUR, pkg.USD, bad)
+				Expect(err).To(MatchError(pkg.RateParseError), bad)
+			}
+		})
+
+		It("marks up by the spread", func() {
+			Expect(toEuro.WithSpread(250).String()).To(Equal("1.1144825"))
+			Expect(toEuro.WithSpread(0)).To(Equal(toEuro))
+		})
+
+		It("converts with the given rounding", func() {
+			rate := toEuro.WithSpread(250)
+			Expect(rate.Convert(euros(100), pkg.RoundHalfUp)).To(Equal(pkg.NewAmount(111, 45)))
+			Expect(rate.Convert(euros(100), pkg.RoundCeiling)).To(Equal(pkg.NewAmount(111, 45)))
+			Expect(rate.Convert(euros(100), pkg.RoundFloor)).To(Equal(pkg.NewAmount(111, 44)))
+			Expect(rate.Convert(euros(-100), pkg.RoundFloor)).To(Equal(pkg.NewAmount(-111, -45)))
+			Expect(func() { rate.Convert(pkg.Dollars(1), pkg.RoundHalfUp) }).To(Panic())
+		})
+
+		It("returns errors from checked conversions rather than panicking", func() {
+			_, err := toEuro.ConvertChecked(pkg.Dollars(1), pkg.RoundHalfUp)
+			Expect(err).To(MatchError(pkg.CurrencyMismatchError))
+			huge := mustParseRate(pkg.EUR, pkg.USD, "90000000000")
+			_, err = huge.ConvertChecked(pkg.MajorAmount(pkg.EUR, 100000000), pkg.RoundHalfUp)
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+			Expect(toEuro.ConvertChecked(euros(100), pkg.RoundHalfUp)).To(Equal(pkg.NewAmount(108, 73)))
+		})
+
+		It("converts between currencies with different minor units", func() {
+			yen := pkg.NewStaticRates(mustParseRate(pkg.USD, pkg.JPY, "150"))
+			rate, err := yen.Rate(ctx, pkg.USD, pkg.JPY)
+			Expect(err).To(BeNil())
+			Expect(rate.Convert(pkg.NewAmount(12, 34), pkg.RoundHalfUp)).To(Equal(pkg.MajorAmount(pkg.JPY, 1851)))
+			rate, err = yen.Rate(ctx, pkg.JPY, pkg.USD)
+			Expect(err).To(BeNil())
+			Expect(rate.String()).To(Equal("0.00666667"))
+			Expect(rate.Convert(pkg.MajorAmount(pkg.JPY, 1000), pkg.RoundHalfUp)).To(Equal(pkg.NewAmount(6, 67)))
+		})
+
+		It("says when a rate isn't available", func() {
+			_, err := pkg.NewStaticRates(toEuro).Rate(ctx, pkg.GBP, pkg.USD)
+			Expect(err).To(MatchError(pkg.RateUnavailableError))
+		})
+
+		It("loads a rate table from a file", func() {
+			dir, err := ioutil.TempDir("", "rates")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "rates.json")
+			Expect(ioutil.WriteFile(path, []byte(`[{"from": "eur", "to": "USD", "rate": "1.0873"}]`), 0644)).To(Succeed())
+			rates, err := pkg.OpenRateFile(path)
+			Expect(err).To(BeNil())
+			Expect(rates.Rate(ctx, pkg.EUR, pkg.USD)).To(Equal(toEuro))
+
+			Expect(ioutil.WriteFile(path, []byte(`[{"from": "EUR", "to": "USD", "rate": "lots"}]`), 0644)).To(Succeed())
+			_, err = pkg.OpenRateFile(path)
+			Expect(err).To(MatchError(pkg.RateParseError))
+		})
+	})
+
+	Context("withdrawals", func() {
+		It("debits the account for foreign cash at the marked up rate", func() {
+			txn, err := atm.Withdraw(ctx, token, euros(100))
+			Expect(err).To(BeNil())
+			Expect(txn.Amount).To(Equal(pkg.NewAmount(-111, -45)))
+			Expect(txn.Dispensed).To(Equal(pkg.Cassettes{{Denomination: euros(50), Count: 2}}))
+			Expect(txn.Conversion).To(Equal(&pkg.Conversion{
+				Foreign: euros(100),
+				Home:    pkg.NewAmount(111, 45),
+				Rate:    toEuro.WithSpread(250),
+			}))
+			Expect(txn.CashDispensed()).To(Equal(euros(100)))
+			Expect(atm.Balance(ctx, token)).To(Equal(pkg.NewAmount(888, 55)))
+		})
+
+		It("keeps each currency's cash separate", func() {
+			_, err := atm.Withdraw(ctx, token, euros(700))
+			Expect(err).To(BeNil())
+			_, err = atm.Withdraw(ctx, token, euros(20))
+			Expect(err).To(Equal(pkg.NoMoneyError))
+			txn, err := atm.Withdraw(ctx, token, pkg.Dollars(200))
+			Expect(err).To(BeNil())
+			Expect(txn.Conversion).To(BeNil())
+			Expect(txn.Amount).To(Equal(pkg.Dollars(-200)))
+		})
+
+		It("refuses currencies it has no cash or rate for", func() {
+			_, err := atm.Withdraw(ctx, token, pkg.MajorAmount(pkg.GBP, 20))
+			Expect(err).To(MatchError(pkg.RateUnavailableError))
+			_, err = atm.Withdraw(ctx, token, euros(15))
+			Expect(err).To(Equal(pkg.InvalidAmountError))
+			Expect(atm.Balance(ctx, token)).To(Equal(pkg.Dollars(1000)))
+		})
+
+		It("needs an exchange to dispense foreign cash", func() {
+			Expect(atm.Close()).To(Succeed())
+			newAtm(pkg.NewCassetteDispenser(pkg.Cassette{Denomination: euros(50), Count: 10}), nil)
+			_, err := atm.Withdraw(ctx, token, euros(50))
+			Expect(err).To(MatchError(pkg.CurrencyMismatchError))
+		})
+
+		It("refuses withdrawals a provider gives a bad rate for", func() {
+			Expect(atm.Close()).To(Succeed())
+			wrong := mustParseRate(pkg.GBP, pkg.USD, "1.25")
+			newAtm(pkg.NewCassetteDispenser(pkg.Cassette{Denomination: euros(50), Count: 10}),
+				&pkg.Exchange{Rates: fixedRate{wrong}})
+			_, err := atm.Withdraw(ctx, token, euros(50))
+			Expect(err).To(MatchError(pkg.CurrencyMismatchError))
+			Expect(atm.Balance(ctx, token)).To(Equal(pkg.Dollars(1000)))
+		})
+
+		It("credits back notes that weren't dispensed at the same rate", func() {
+			Expect(atm.Close()).To(Succeed())
+			jammed := &jammingDispenser{
+				CashDispenser: pkg.NewCassetteDispenser(pkg.Cassette{Denomination: euros(50), Count: 10}),
+				notes:         1,
+			}
+			newAtm(jammed, &pkg.Exchange{Rates: pkg.NewStaticRates(toEuro), SpreadBasisPoints: 250})
+			txn, err := atm.Withdraw(ctx, token, euros(100))
+			Expect(err).To(BeNil())
+			Expect(txn.Reversals).To(HaveLen(1))
+			Expect(txn.Reversals[0].Amount).To(Equal(pkg.NewAmount(55, 73)))
+			Expect(txn.NetAmount()).To(Equal(pkg.NewAmount(-55, -72)))
+			Expect(txn.CashDispensed()).To(Equal(euros(50)))
+			Expect(atm.Balance(ctx, token)).To(Equal(pkg.NewAmount(944, 28)))
+		})
+
+		It("shows the conversion on the receipt", func() {
+			ui := pkg.NewInterface(atm, pkg.TextFormat, nil)
+			_, err := atm.Logout(ctx, token)
+			Expect(err).To(BeNil())
+			Expect(ui.Execute(ctx, "authorize "+id+" "+pin)).To(Equal(pkg.AuthorizedMessage(id)))
+			Expect(ui.Execute(ctx, "withdraw 100 eur")).To(Equal("Amount dispensed: €100.00\n" +
+				"Exchange rate: 1 EUR = 1.1144825 USD. Your account has been charged $111.45.\n" +
+				"Current balance: $888.55"))
+			Expect(ui.Execute(ctx, "history")).To(MatchRegexp(`withdrawal -\$111\.45 \$888\.55 terminal=ATM-TEST foreign=EUR100\.00 rate=1\.1144825$`))
+			Expect(ui.Respond(ctx, "withdraw 100 xyz").ErrorCode).To(Equal(pkg.UnknownCurrencyError.Code))
+			Expect(ui.Close()).To(Succeed())
+		})
+	})
+})
+
+// A provider that answers every request with the same rate, whatever currencies were asked for.
+type fixedRate struct {
+	rate pkg.Rate
+}
+
+func (f fixedRate) Rate(ctx context.Context, from, to pkg.Currency) (pkg.Rate, error) {
+	return f.rate, nil
+}
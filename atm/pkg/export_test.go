@@ -0,0 +1,24 @@
+package pkg
+
+import "errors"
+
+// Make the journal's next write stop after n bytes and fail, as it would on a full disk.
+func FailNextJournalWrite(journal Journal, n int) {
+	j := journal.(*fileJournal)
+	j.file = &failingFile{journalFile: j.file, n: n}
+}
+
+type failingFile struct {
+	journalFile
+	n      int
+	failed bool
+}
+
+func (f *failingFile) WriteString(s string) (int, error) {
+	if f.failed {
+		return f.journalFile.WriteString(s)
+	}
+	f.failed = true
+	written, _ := f.journalFile.WriteString(s[:f.n])
+	return written, errors.New("disk full")
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	StoreCorruptedError = NewATMError("store_corrupted", InternalCategory, "Account store is corrupted.")
+
+	_ AccountStore = new(fileStore)
+)
+
+// How many postings a file store's journal holds before they're folded into its file.
+const fileStoreCompactAfter = 1000
+
+// Open a store that keeps accounts in a JSON file, written in the schema described on
+// SchemaVersion with each account's PIN hash added. Postings are appended to a journal next to it,
+// in path + ".journal", and folded into the file every fileStoreCompactAfter postings, so the
+// file itself is only rewritten then and when accounts are added. Rewrites are atomic, so a crash
+// leaves either the old or the new contents on disk and never a mix of the two. The file is
+// created on the first save if it doesn't exist yet. A file or journal that can't be read back,
+// such as one with amounts out of range, fails with StoreCorruptedError.
+//
+// The store keeps its own journal, so an atm using it shouldn't be given another one.
+func OpenFileStore(clock Clock, path string) (AccountStore, error) {
+	journal, err := openFileJournal(clock, path+".journal", path, fileStoreCompactAfter)
+	if err != nil {
+		return nil, err
+	}
+	accounts := NewMemoryStore()
+	if err := journal.Restore(accounts); err != nil {
+		journal.Close()
+		if errors.Is(err, JournalCorruptedError) {
+			err = StoreCorruptedError.WithCause(err)
+		}
+		return nil, fmt.Errorf("reading accounts from %s: %w", path, err)
+	}
+	return &fileStore{
+		accounts: accounts,
+		journal:  journal,
+		mutex:    &sync.Mutex{},
+	}, nil
+}
+
+// The accounts are kept in memory, and every change to them is written through the journal.
+type fileStore struct {
+	accounts AccountStore
+	journal  *fileJournal
+	mutex    *sync.Mutex
+}
+
+func (s *fileStore) Get(id string) (Account, error) {
+	return s.accounts.Get(id)
+}
+
+func (s *fileStore) List() ([]Account, error) {
+	return s.accounts.List()
+}
+
+// Adding an account, or changing one other than through Post, rewrites the file.
+func (s *fileStore) Save(account Account) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.accounts.Save(account); err != nil {
+		return err
+	}
+	account.SetJournal(s.journal)
+	return s.compact()
+}
+
+// The posting is appended to the store's journal before the account applies it, whatever journal
+// the account was last given. Postings all go through the mutex, so the journal can be compacted
+// without taking the accounts' locks.
+func (s *fileStore) Post(account Account, txn *Transaction) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account.SetJournal(s.journal)
+	if err := account.Commit(txn); err != nil {
+		return err
+	}
+	if s.journal.due() {
+		// A failed compaction leaves the journal as it was, so the posting is safe either way.
+		_ = s.compact()
+	}
+	return nil
+}
+
+func (s *fileStore) Lock(id string) (func(), error) {
+	return s.accounts.Lock(id)
+}
+
+// Fold the journal into the file. Must be called with the mutex held.
+func (s *fileStore) compact() error {
+	accounts, err := s.accounts.List()
+	if err != nil {
+		return err
+	}
+	return s.journal.compact(accounts)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// The rename isn't durable until the directory holding the file is synced too.
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -1,139 +1,155 @@
 package pkg
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"strings"
+	"sync"
 )
 
-const (
-	HelpMessage          = "Must provide command: authorize, withdraw, deposit, balance, history, logout, or end"
-	HelpAuthorizeMessage = "Authorize command requires two arguments: <id> <pin>"
-	HelpWithdrawMessage  = "Withdraw command requires one argument: <value>"
-	HelpDepositMessage   = "Deposit command requires one argument: <value>"
-)
-
+// The messages in English. Interfaces word theirs with the catalog for the customer's language.
 var (
-	AuthorizedMessage = func(id string) string {
-		return fmt.Sprintf("%s successfully authorized.", id)
-	}
-
-	BalanceMessage = func(amount Amount) string {
-		return fmt.Sprintf("Current balance: %v", amount)
-	}
-
-	WithdrawMessage = func(desiredAmt Amount, txn *Transaction) string {
-		msg := ""
-		if desiredAmt.GreaterThan(txn.Amount.Abs()) {
-			msg += fmt.Sprintf("Unable to dispense full amount requested at this time. ")
-		}
-		msg += fmt.Sprintf("Amount dispensed: $%v\n", txn.Amount.Abs())
-		if txn.Overdraft {
-			msg += "You have been charged an overdraft fee of $5. "
-		}
-		msg += BalanceMessage(txn.Balance)
-		return msg
-	}
+	AuthorizedMessage     = EnglishCatalog.AuthorizedMessage
+	BalanceMessage        = EnglishCatalog.BalanceMessage
+	WithdrawMessage       = EnglishCatalog.WithdrawMessage
+	HistoryMessage        = EnglishCatalog.HistoryMessage
+	LogoutMessage         = EnglishCatalog.LogoutMessage
+	TimeoutWarningMessage = EnglishCatalog.TimeoutWarningMessage
+)
 
-	HistoryMessage = func(history []Transaction) string {
-		msg := ""
-		for i := len(history) - 1; i >= 0; i-- {
-			msg += fmt.Sprintf("%v", history[i])
-			if i > 0 {
-				msg += "\n"
-			}
-		}
-		return msg
-	}
+// Create a text interface that renders its responses in the given format. Notices the customer
+// should see that aren't a reply to a command, such as a warning that their session is about to
+// time out, are rendered the same way and passed to notify, which may be called on any goroutine.
+// A nil notify drops them.
+func NewInterface(atm Atm, format OutputFormat, notify func(message string)) TextInterface {
+	return NewInterfaceWithCommands(atm, DefaultCommands, format, notify)
+}
 
-	LogoutMessage = func(accountId string) string {
-		return fmt.Sprintf("Account %s logged out.", accountId)
+// Create a text interface that understands the given commands rather than DefaultCommands.
+func NewInterfaceWithCommands(atm Atm, commands *CommandRegistry, format OutputFormat, notify func(message string)) TextInterface {
+	return &textInterface{
+		atm:      atm,
+		commands: commands,
+		catalog:  EnglishCatalog,
+		format:   format,
+		notify:   notify,
+		mutex:    &sync.Mutex{},
 	}
-)
-
-func NewInterface(atm Atm) TextInterface {
-	return &textInterface{atm: atm}
 }
 
 type TextInterface interface {
-	Execute(command string) string
+	// Run a command and render the response.
+	Execute(ctx context.Context, command string) string
+	// Run a command and return the response as is.
+	Respond(ctx context.Context, command string) Response
+	// Log out of the current session, if there is one.
+	Close() error
 }
 
+// Each text interface is a single customer at the atm, with at most one session at a time.
 type textInterface struct {
-	atm Atm
+	atm      Atm
+	commands *CommandRegistry
+	catalog  *Catalog
+	token    string
+	format   OutputFormat
+	notify   func(message string)
+	mutex    *sync.Mutex
 }
 
-func (t *textInterface) Execute(command string) string {
+func (t *textInterface) Execute(ctx context.Context, command string) string {
+	return t.Respond(ctx, command).Render(t.format)
+}
+
+func (t *textInterface) Respond(ctx context.Context, command string) Response {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	fields := strings.Fields(command)
 	if len(fields) == 0 {
-		return HelpMessage
+		return NewUsageResponse("", t.catalog.CommandList(t.commands.Commands()))
 	}
 
-	switch fields[0] {
-	case "authorize":
-		if len(fields) != 3 {
-			return HelpAuthorizeMessage
-		} else {
-			if err := t.atm.Authorize(fields[1], fields[2]); err != nil {
-				return err.Error()
-			} else {
-				return AuthorizedMessage(fields[1])
-			}
-		}
-	case "withdraw":
-		if len(fields) != 2 {
-			return HelpWithdrawMessage
-		}
-		amount, err := ParseAmount(fields[1])
-		if err != nil {
-			return err.Error()
-		} else {
-			txn, err := t.atm.Withdraw(amount)
-			if err != nil {
-				return err.Error()
-			} else {
-				return WithdrawMessage(amount, txn)
-			}
-		}
-	case "deposit":
-		if len(fields) != 2 {
-			return HelpDepositMessage
-		}
-		amount, err := ParseAmount(fields[1])
-		if err != nil {
-			return err.Error()
-		} else {
-			err := t.atm.Deposit(amount)
-			if err != nil {
-				return err.Error()
-			} else {
-				return t.balance()
-			}
-		}
-	case "balance":
-		return t.balance()
-	case "history":
-		history, err := t.atm.History()
-		if err != nil {
-			return err.Error()
-		} else {
-			return HistoryMessage(history)
+	name, args := fields[0], fields[1:]
+	cmd, err := t.commands.resolve(name, t.catalog)
+	if err != nil {
+		return NewErrorResponse(name, err)
+	}
+	if !cmd.accepts(args) {
+		return NewUsageResponse(cmd.Name, t.catalog.Usage(cmd))
+	}
+	session := &CommandSession{Atm: t.atm, Commands: t.commands, Catalog: t.catalog, Token: t.token}
+	response := cmd.Handler(ctx, session, args)
+	response.Command = cmd.Name
+	t.catalog = session.Catalog
+	if response.Status == ResponseError {
+		response.Message = t.catalog.ErrorMessage(response.ErrorCode, response.Message)
+	}
+	if session.Token != t.token {
+		t.token = session.Token
+		if t.token != "" {
+			_ = t.atm.Listen(ctx, t.token, t.sessionEvent)
 		}
-	case "logout":
-		accountId, err := t.atm.Logout()
-		if err != nil {
-			return err.Error()
-		} else {
-			return LogoutMessage(accountId)
+	}
+	return response
+}
+
+func newWithdrawResponse(catalog *Catalog, command string, requested Amount, txn *Transaction) Response {
+	dispensed := txn.CashDispensed()
+	balance := txn.ClosingBalance()
+	var fees []Transaction
+	for _, fee := range txn.Fees {
+		if !txn.Refunded(fee) {
+			fees = append(fees, fee)
 		}
 	}
-	return HelpMessage
+	return Response{
+		Command:     command,
+		Status:      ResponseOk,
+		Message:     catalog.WithdrawMessage(requested, txn),
+		Balance:     &balance,
+		Requested:   &requested,
+		Dispensed:   &dispensed,
+		Transaction: txn,
+		Fees:        fees,
+	}
 }
 
-func (t *textInterface) balance() string {
-	balance, err := t.atm.Balance()
-	if err != nil {
-		return err.Error()
-	} else {
-		return BalanceMessage(balance)
+func (t *textInterface) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.token == "" {
+		return nil
+	}
+	_, err := t.atm.Logout(context.Background(), t.token)
+	t.token = ""
+	if errors.Is(err, NotAuthorizedError) {
+		// The session has already timed out.
+		return nil
+	}
+	return err
+}
+
+func (t *textInterface) sessionEvent(event SessionEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if event.Token != t.token {
+		return
+	}
+	response := Response{
+		Status:    ResponseNotice,
+		Event:     event.Type,
+		AccountId: event.AccountId,
+	}
+	switch event.Type {
+	case SessionWarning:
+		response.Message = t.catalog.TimeoutWarningMessage(event.Remaining)
+	case SessionExpired:
+		t.token = ""
+		response.Message = t.catalog.LogoutMessage(event.AccountId)
+	default:
+		return
+	}
+	if t.notify != nil {
+		t.notify(response.Render(t.format))
 	}
 }
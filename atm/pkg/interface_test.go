@@ -1,6 +1,7 @@
 package pkg_test
 
 import (
+	"context"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -21,67 +22,150 @@ var _ = Describe("Text Interface", func() {
 		account1, account2 pkg.Account
 		atm                pkg.Atm
 		ui                 pkg.TextInterface
-		done               chan bool
+		clock              *pkg.ManualClock
 
+		ctx     = context.Background()
 		amount1 = pkg.Dollars(20000)
 		amount2 = pkg.Dollars(25)
+
+		cassettes = []pkg.Cassette{
+			{Denomination: pkg.Dollars(50), Count: 100},
+			{Denomination: pkg.Dollars(20), Count: 250},
+		}
 	)
 
 	BeforeEach(func() {
-		account1 = pkg.NewAccount(id1, pin1, amount1)
-		account2 = pkg.NewAccount(id2, pin2, amount2)
-		atm, done = pkg.NewAtm(1, account1, account2)
-		ui = pkg.NewInterface(atm)
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account1 = pkg.NewAccount(clock, id1, pin1, amount1, pkg.DefaultOverdraftPolicy)
+		account2 = pkg.NewAccount(clock, id2, pin2, amount2, pkg.DefaultOverdraftPolicy)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account1, account2), nil)
+		Expect(err).To(BeNil())
+		ui = pkg.NewInterface(atm, pkg.TextFormat, nil)
 	})
 
 	AfterEach(func() {
-		// prevent goroutine leak
-		done <- true
+		Expect(atm.Close()).To(Succeed())
 	})
 
 	It("handles unknown command", func() {
-		msg := ui.Execute("foo")
+		msg := ui.Execute(ctx, "foo")
 		Expect(msg).To(Equal(pkg.HelpMessage))
 	})
 
 	It("handles no command", func() {
-		msg := ui.Execute("")
+		msg := ui.Execute(ctx, "")
 		Expect(msg).To(Equal(pkg.HelpMessage))
 	})
 
 	It("handles not authorized for command", func() {
-		msg := ui.Execute("balance")
+		msg := ui.Execute(ctx, "balance")
 		Expect(msg).To(Equal(pkg.AuthorizationRequiredError.Error()))
 	})
 
 	It("handles invalid authorization", func() {
-		msg := ui.Execute("authorize foo bar")
+		msg := ui.Execute(ctx, "authorize foo bar")
 		Expect(msg).To(Equal(pkg.AuthorizationFailedError.Error()))
 	})
 
 	It("handles valid authorization", func() {
-		msg := ui.Execute(fmt.Sprintf("authorize %s %s", id1, pin1))
+		msg := ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
 		Expect(msg).To(Equal(pkg.AuthorizedMessage(id1)))
 	})
 
 	It("handles valid authorization", func() {
-		_ = ui.Execute(fmt.Sprintf("authorize %s %s", id1, pin1))
-		msg := ui.Execute("balance")
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		msg := ui.Execute(ctx, "balance")
 		Expect(msg).To(Equal(pkg.BalanceMessage(amount1)))
 	})
 
+	It("logs out of the previous account when authorizing another", func() {
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id2, pin2))
+		Expect(ui.Execute(ctx, "balance")).To(Equal(pkg.BalanceMessage(amount2)))
+		msg := ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Expect(msg).To(Equal(pkg.AuthorizedMessage(id1)))
+	})
+
+	It("gives each interface its own session", func() {
+		other := pkg.NewInterface(atm, pkg.TextFormat, nil)
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		_ = other.Execute(ctx, fmt.Sprintf("authorize %s %s", id2, pin2))
+		Expect(ui.Execute(ctx, "balance")).To(Equal(pkg.BalanceMessage(amount1)))
+		Expect(other.Execute(ctx, "balance")).To(Equal(pkg.BalanceMessage(amount2)))
+		msg := other.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Expect(msg).To(Equal(pkg.AccountInUseError.Error()))
+	})
+
+	It("tells the customer when their session is about to time out", func() {
+		Expect(atm.Close()).To(Succeed())
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 30, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account1, account2), nil)
+		Expect(err).To(BeNil())
+		notices := []string{}
+		ui = pkg.NewInterface(atm, pkg.TextFormat, func(message string) { notices = append(notices, message) })
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		clock.Advance(90 * time.Second)
+		Expect(notices).To(Equal([]string{pkg.TimeoutWarningMessage(30 * time.Second)}))
+		clock.Advance(30 * time.Second)
+		Expect(notices).To(Equal([]string{pkg.TimeoutWarningMessage(30 * time.Second), pkg.LogoutMessage(id1)}))
+		Expect(ui.Execute(ctx, "balance")).To(Equal(pkg.AuthorizationRequiredError.Error()))
+	})
+
+	It("logs out when closed", func() {
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Expect(ui.Close()).To(Succeed())
+		Expect(ui.Execute(ctx, "balance")).To(Equal(pkg.AuthorizationRequiredError.Error()))
+		_, err := atm.Authorize(ctx, id1, pin1)
+		Expect(err).To(BeNil())
+		Expect(ui.Close()).To(Succeed())
+	})
+
+	It("responds with structured results", func() {
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id2, pin2))
+		response := ui.Respond(ctx, "withdraw 40")
+		Expect(response.Status).To(Equal(pkg.ResponseOk))
+		Expect(*response.Requested).To(Equal(pkg.Dollars(40)))
+		Expect(*response.Dispensed).To(Equal(pkg.Dollars(40)))
+		Expect(*response.Balance).To(Equal(pkg.Dollars(-20)))
+		Expect(response.Fees).To(HaveLen(1))
+		Expect(response.Fees[0].Amount).To(Equal(pkg.Dollars(-5)))
+
+		response = ui.Respond(ctx, "history")
+		Expect(response.Transactions).To(HaveLen(2))
+		Expect(response.Transactions[0].Type).To(Equal(pkg.FeeTransaction))
+
+		response = ui.Respond(ctx, "withdraw 20")
+		Expect(response.Status).To(Equal(pkg.ResponseError))
+		Expect(response.ErrorCode).To(Equal("account_overdrawn"))
+
+		response = ui.Respond(ctx, "withdraw twenty")
+		Expect(response.ErrorCode).To(Equal(pkg.AmountParseError.Code))
+		Expect(ui.Respond(ctx, "withdraw").ErrorCode).To(Equal(pkg.UsageError.Code))
+	})
+
+	It("renders JSON when asked to", func() {
+		ui = pkg.NewInterface(atm, pkg.JsonFormat, nil)
+		msg := ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Expect(msg).To(MatchJSON(`{"command": "authorize", "status": "ok", "message": "12345 successfully authorized.", "account_id": "12345"}`))
+		msg = ui.Execute(ctx, "balance")
+		Expect(msg).To(MatchJSON(`{"command": "balance", "status": "ok", "message": "Current balance: $20,000.00", "balance": {"amount": "20000.00", "currency": "USD"}}`))
+		msg = ui.Execute(ctx, "foo")
+		Expect(msg).To(MatchJSON(fmt.Sprintf(`{"command": "foo", "status": "error", "error_code": "usage", "error_category": "input", "message": %q}`, pkg.HelpMessage)))
+	})
+
 	It("handles deposit", func() {
-		_ = ui.Execute(fmt.Sprintf("authorize %s %s", id1, pin1))
-		msg := ui.Execute("deposit 500")
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		msg := ui.Execute(ctx, "deposit 500")
 		Expect(msg).To(Equal(pkg.BalanceMessage(amount1.Add(pkg.Dollars(500)))))
 	})
 
 	It("handles withdraw", func() {
-		_ = ui.Execute(fmt.Sprintf("authorize %s %s", id1, pin1))
-		msg := ui.Execute("withdraw 500")
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		msg := ui.Execute(ctx, "withdraw 500")
 		withdrawAmt := pkg.Dollars(500)
 		txn := pkg.Transaction{
-			Date:      time.Now(),
+			Date:      clock.Now(),
 			Amount:    withdrawAmt.Negative(),
 			Balance:   amount1.Subtract(withdrawAmt),
 			Overdraft: false,
@@ -90,25 +174,39 @@ var _ = Describe("Text Interface", func() {
 	})
 
 	It("handles withdraw overdraft", func() {
-		_ = ui.Execute(fmt.Sprintf("authorize %s %s", id2, pin2))
-		msg := ui.Execute("withdraw 40")
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id2, pin2))
+		msg := ui.Execute(ctx, "withdraw 40")
 		withdrawAmt := pkg.Dollars(40)
 		txn := pkg.Transaction{
-			Date:      time.Now(),
+			Date:      clock.Now(),
 			Amount:    withdrawAmt.Negative(),
-			Balance:   amount2.Subtract(withdrawAmt).Subtract(pkg.OverdraftFee),
+			Balance:   amount2.Subtract(withdrawAmt),
 			Overdraft: true,
+			Fees: []pkg.Transaction{{
+				Type:    pkg.FeeTransaction,
+				Memo:    pkg.OverdraftFeeMemo,
+				Amount:  pkg.Dollars(-5),
+				Balance: amount2.Subtract(withdrawAmt).Subtract(pkg.Dollars(5)),
+			}},
 		}
 		Expect(msg).To(Equal(pkg.WithdrawMessage(withdrawAmt, &txn)))
 	})
 
+	It("shows fees in the history", func() {
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id2, pin2))
+		_ = ui.Execute(ctx, "withdraw 40")
+		msg := ui.Execute(ctx, "history")
+		Expect(msg).To(MatchRegexp(`fee -\$5\.00 -\$20\.00 terminal=ATM-TEST parent=\w+ memo="overdraft fee"\n`))
+		Expect(msg).To(HaveSuffix("withdrawal -$40.00 -$15.00 terminal=ATM-TEST"))
+	})
+
 	It("handles withdraw run out of money", func() {
-		_ = ui.Execute(fmt.Sprintf("authorize %s %s", id1, pin1))
-		msg := ui.Execute("withdraw 20000")
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		msg := ui.Execute(ctx, "withdraw 20000")
 		desiredAmt := pkg.Dollars(20000)
 		withdrawAmt := pkg.Dollars(10000)
 		txn := pkg.Transaction{
-			Date:      time.Now(),
+			Date:      clock.Now(),
 			Amount:    withdrawAmt.Negative(),
 			Balance:   amount1.Subtract(withdrawAmt),
 			Overdraft: false,
@@ -117,9 +215,9 @@ var _ = Describe("Text Interface", func() {
 	})
 
 	It("handles run out of money", func() {
-		_ = ui.Execute(fmt.Sprintf("authorize %s %s", id1, pin1))
-		_ = ui.Execute("withdraw 20000")
-		msg := ui.Execute("withdraw 20")
+		_ = ui.Execute(ctx, fmt.Sprintf("authorize %s %s", id1, pin1))
+		_ = ui.Execute(ctx, "withdraw 20000")
+		msg := ui.Execute(ctx, "withdraw 20")
 		Expect(msg).To(Equal(pkg.NoMoneyError.Error()))
 	})
 
@@ -0,0 +1,315 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	JournalCorruptedError = NewATMError("journal_corrupted", InternalCategory, "Journal is corrupted.")
+
+	_ Journal = new(fileJournal)
+)
+
+// An append-only log of every posting made to every account. Together with its latest snapshot,
+// the journal is enough to rebuild all balances and histories after a restart.
+type Journal interface {
+	// Durably record postings made to an account. Postings recorded together are replayed together.
+	Record(accountId string, postings []Transaction) error
+	// Bring the accounts in the store up to date with the latest snapshot and every posting recorded
+	// since, then start recording their future postings. Postings the accounts already have (matched
+	// by transaction id) are skipped, so restoring into an up to date store is harmless.
+	Restore(store AccountStore) error
+	// Snapshot every account in the store and empty the journal.
+	Compact(store AccountStore) error
+	// Compact the journal if enough records have built up since the last snapshot.
+	Checkpoint(store AccountStore) error
+	Close() error
+}
+
+// Open a journal at the given path, keeping its snapshot alongside it in path + ".snapshot". The
+// journal is compacted by Checkpoint once it holds compactAfter records; zero means never.
+func OpenJournal(clock Clock, path string, compactAfter int) (Journal, error) {
+	return openFileJournal(clock, path, path+".snapshot", compactAfter)
+}
+
+func openFileJournal(clock Clock, path, snapshotPath string, compactAfter int) (*fileJournal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileJournal{
+		clock:        clock,
+		path:         path,
+		snapshotPath: snapshotPath,
+		compactAfter: compactAfter,
+		file:         file,
+		mutex:        &sync.Mutex{},
+	}, nil
+}
+
+type fileJournal struct {
+	clock        Clock
+	path         string
+	snapshotPath string
+	compactAfter int
+	file         journalFile
+	records      int
+	mutex        *sync.Mutex
+}
+
+// The parts of an *os.File the journal uses.
+type journalFile interface {
+	io.StringWriter
+	io.Seeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// Postings are written as in the schema described on SchemaVersion.
+type journalRecord struct {
+	AccountId string        `json:"account_id"`
+	Postings  []Transaction `json:"postings"`
+}
+
+// Each record is written as a single line: the CRC-32 of the JSON payload in hex, a space, then the
+// payload itself. The file is synced before Record returns. If the line can't be written in full,
+// whatever part of it was is cut off again, so the next record doesn't follow a torn one.
+func (j *fileJournal) Record(accountId string, postings []Transaction) error {
+	payload, err := json.Marshal(journalRecord{AccountId: accountId, Postings: postings})
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	offset, err := j.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := j.write(line); err != nil {
+		_ = j.file.Truncate(offset)
+		return err
+	}
+	j.records++
+	return nil
+}
+
+// Must be called with the mutex held.
+func (j *fileJournal) write(line string) error {
+	if _, err := j.file.WriteString(line); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *fileJournal) Restore(store AccountStore) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.restoreSnapshot(store); err != nil {
+		return err
+	}
+	records, err := j.read()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]map[string]bool)
+	for _, record := range records {
+		account, err := store.Get(record.AccountId)
+		if err != nil {
+			return fmt.Errorf("replaying journal for account %s: %w", record.AccountId, err)
+		}
+		ids, ok := seen[record.AccountId]
+		if !ok {
+			ids = make(map[string]bool)
+			for _, txn := range account.History() {
+				ids[txn.Id] = true
+			}
+			seen[record.AccountId] = ids
+		}
+		changed := false
+		for _, posting := range record.Postings {
+			if !ids[posting.Id] {
+				if err := checkPostingCurrency(account.Currency(), posting); err != nil {
+					return fmt.Errorf("replaying journal for account %s: %w", record.AccountId, JournalCorruptedError.WithCause(err))
+				}
+				account.Apply(posting)
+				ids[posting.Id] = true
+				changed = true
+			}
+		}
+		if changed {
+			if err := store.Save(account); err != nil {
+				return err
+			}
+		}
+	}
+	j.records = len(records)
+
+	accounts, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		account.SetJournal(j)
+	}
+	return nil
+}
+
+// Replace the accounts in the store with the ones in the snapshot, if there is one.
+func (j *fileJournal) restoreSnapshot(store AccountStore) error {
+	data, err := ioutil.ReadFile(j.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var records []storedAccountJSON
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("reading snapshot %s: %w", j.snapshotPath, JournalCorruptedError.WithCause(err))
+	}
+	for _, record := range records {
+		snapshot, err := record.snapshot()
+		if err != nil {
+			return fmt.Errorf("reading snapshot %s: %w", j.snapshotPath, JournalCorruptedError.WithCause(err))
+		}
+		if err := store.Save(RestoreAccount(j.clock, snapshot)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read every record in the journal. A damaged final record is what a crash part way through a
+// write leaves behind, so it's cut off and the rest of the journal is used as normal. Damage
+// anywhere else, or a record that was written whole but can't be read back, means the journal
+// can't be trusted and is reported as JournalCorruptedError. Must be called with the mutex held.
+func (j *fileJournal) read() ([]journalRecord, error) {
+	data, err := ioutil.ReadFile(j.path)
+	if err != nil {
+		return nil, err
+	}
+	var records []journalRecord
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		last := end < 0 || offset+end+1 == len(data)
+		var line []byte
+		if end < 0 {
+			line = data[offset:]
+		} else {
+			line = data[offset : offset+end]
+		}
+		payload, ok := journalPayload(line)
+		if !ok || end < 0 {
+			if !last {
+				return nil, JournalCorruptedError
+			}
+			if err := j.file.Truncate(int64(offset)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		var record journalRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return nil, JournalCorruptedError.WithCause(err)
+		}
+		records = append(records, record)
+		offset += end + 1
+	}
+	return records, nil
+}
+
+// The payload of a journal line, if its checksum matches.
+func journalPayload(line []byte) ([]byte, bool) {
+	space := bytes.IndexByte(line, ' ')
+	if space < 0 {
+		return nil, false
+	}
+	checksum, err := strconv.ParseUint(string(line[:space]), 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	payload := line[space+1:]
+	if crc32.ChecksumIEEE(payload) != uint32(checksum) {
+		return nil, false
+	}
+	return payload, true
+}
+
+// Accounts are locked while the snapshot is taken so no posting can be recorded in the journal
+// without also making it into the snapshot. Crashing after the snapshot is written but before the
+// journal is emptied is safe, as replaying postings the snapshot already has is a no-op.
+func (j *fileJournal) Compact(store AccountStore) error {
+	accounts, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		unlock, err := store.Lock(account.GetId())
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return j.compact(accounts)
+}
+
+// Snapshot the accounts and empty the journal. The caller must make sure nothing is posted to them
+// until it's done.
+func (j *fileJournal) compact(accounts []Account) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	records := make([]storedAccountJSON, 0, len(accounts))
+	for _, account := range accounts {
+		record, err := newStoredAccountJSON(account.Snapshot())
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(j.snapshotPath, data); err != nil {
+		return err
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.records = 0
+	return nil
+}
+
+func (j *fileJournal) Checkpoint(store AccountStore) error {
+	if !j.due() {
+		return nil
+	}
+	return j.Compact(store)
+}
+
+// Whether enough records have built up to compact the journal.
+func (j *fileJournal) due() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.compactAfter > 0 && j.records >= j.compactAfter
+}
+
+func (j *fileJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
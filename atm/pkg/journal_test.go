@@ -0,0 +1,203 @@
+package pkg_test
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Journal", func() {
+	const (
+		id  = "12345"
+		pin = "1234"
+	)
+
+	var (
+		clock   *pkg.ManualClock
+		dir     string
+		path    string
+		journal pkg.Journal
+		store   pkg.AccountStore
+	)
+
+	newStore := func() pkg.AccountStore {
+		return pkg.NewMemoryStore(pkg.NewAccount(clock, id, pin, pkg.Dollars(100), pkg.DefaultOverdraftPolicy))
+	}
+
+	// Simulate a restart: a fresh store seeded with the original data and a reopened journal.
+	restart := func() (pkg.AccountStore, pkg.Journal) {
+		Expect(journal.Close()).To(Succeed())
+		reopened, err := pkg.OpenJournal(clock, path, 0)
+		Expect(err).To(BeNil())
+		restored := newStore()
+		Expect(reopened.Restore(restored)).To(Succeed())
+		return restored, reopened
+	}
+
+	balance := func(store pkg.AccountStore) pkg.Amount {
+		account, err := store.Get(id)
+		Expect(err).To(BeNil())
+		return account.Balance()
+	}
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		var err error
+		dir, err = ioutil.TempDir("", "journal")
+		Expect(err).To(BeNil())
+		path = filepath.Join(dir, "atm.journal")
+		journal, err = pkg.OpenJournal(clock, path, 0)
+		Expect(err).To(BeNil())
+		store = newStore()
+		Expect(journal.Restore(store)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		journal.Close()
+		os.RemoveAll(dir)
+	})
+
+	transact := func(amount pkg.Amount) *pkg.Transaction {
+		account, err := store.Get(id)
+		Expect(err).To(BeNil())
+		txn, err := account.Transaction(amount, pkg.TransactionDetails{})
+		Expect(err).To(BeNil())
+		return txn
+	}
+
+	It("replays postings on restart", func() {
+		first := transact(pkg.Dollars(-20))
+		transact(pkg.Dollars(-100))
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(-25)))
+		account, _ := store.Get(id)
+		Expect(account.History()).To(HaveLen(3))
+		Expect(account.History()[0].Id).To(Equal(first.Id))
+		Expect(account.History()[2].Type).To(Equal(pkg.FeeTransaction))
+	})
+
+	It("keeps recording after a restart", func() {
+		transact(pkg.Dollars(-20))
+		store, journal = restart()
+		transact(pkg.Dollars(50))
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(130)))
+	})
+
+	It("skips postings the store already has", func() {
+		transact(pkg.Dollars(-20))
+		Expect(journal.Restore(store)).To(Succeed())
+		Expect(balance(store)).To(Equal(pkg.Dollars(80)))
+		account, _ := store.Get(id)
+		Expect(account.History()).To(HaveLen(1))
+	})
+
+	It("drops a torn record at the end of the journal", func() {
+		transact(pkg.Dollars(-20))
+		transact(pkg.Dollars(-30))
+		data, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(path, data[:len(data)-10], 0600)).To(Succeed())
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(80)))
+		transact(pkg.Dollars(-10))
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(70)))
+	})
+
+	It("cuts off a record it couldn't write in full before writing the next", func() {
+		transact(pkg.Dollars(-20))
+		pkg.FailNextJournalWrite(journal, 20)
+		account, err := store.Get(id)
+		Expect(err).To(BeNil())
+		_, err = account.Transaction(pkg.Dollars(-30), pkg.TransactionDetails{})
+		Expect(err).To(MatchError("disk full"))
+		Expect(balance(store)).To(Equal(pkg.Dollars(80)))
+		transact(pkg.Dollars(-10))
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(70)))
+	})
+
+	It("refuses a journal corrupted in the middle", func() {
+		transact(pkg.Dollars(-20))
+		transact(pkg.Dollars(-30))
+		data, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		data[20] ^= 0xff
+		Expect(ioutil.WriteFile(path, data, 0600)).To(Succeed())
+		Expect(journal.Close()).To(Succeed())
+		journal, err = pkg.OpenJournal(clock, path, 0)
+		Expect(err).To(BeNil())
+		Expect(journal.Restore(newStore())).To(Equal(pkg.JournalCorruptedError))
+	})
+
+	It("refuses postings it can't read back", func() {
+		payload := `{"account_id": "12345", "postings": [{"version": 1, "id": "1", "type": "deposit", "date": "2020-08-14T12:00:00Z", "amount": {"amount": "19999999999999999.99", "currency": "USD"}, "balance": {"amount": "19999999999999999.99", "currency": "USD"}}]}`
+		line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE([]byte(payload)), payload)
+		Expect(ioutil.WriteFile(path, []byte(line), 0600)).To(Succeed())
+		Expect(journal.Close()).To(Succeed())
+		var err error
+		journal, err = pkg.OpenJournal(clock, path, 0)
+		Expect(err).To(BeNil())
+		err = journal.Restore(newStore())
+		Expect(err).To(MatchError(pkg.JournalCorruptedError))
+		Expect(err).To(MatchError(pkg.AmountRangeError))
+	})
+
+	It("compacts into a snapshot", func() {
+		transact(pkg.Dollars(-20))
+		Expect(journal.Compact(store)).To(Succeed())
+		info, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(info.Size()).To(BeZero())
+		transact(pkg.Dollars(-30))
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(50)))
+		account, _ := store.Get(id)
+		Expect(account.History()).To(HaveLen(2))
+	})
+
+	It("compacts on checkpoint once enough records build up", func() {
+		Expect(journal.Close()).To(Succeed())
+		var err error
+		journal, err = pkg.OpenJournal(clock, path, 2)
+		Expect(err).To(BeNil())
+		Expect(journal.Restore(store)).To(Succeed())
+		transact(pkg.Dollars(-20))
+		Expect(journal.Checkpoint(store)).To(Succeed())
+		_, err = os.Stat(path + ".snapshot")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		transact(pkg.Dollars(-20))
+		Expect(journal.Checkpoint(store)).To(Succeed())
+		_, err = os.Stat(path + ".snapshot")
+		Expect(err).To(BeNil())
+	})
+
+	It("lets the atm rebuild balances on startup", func() {
+		transact(pkg.Dollars(-20))
+		Expect(journal.Close()).To(Succeed())
+		var err error
+		journal, err = pkg.OpenJournal(clock, path, 0)
+		Expect(err).To(BeNil())
+		cassettes := []pkg.Cassette{{Denomination: pkg.Dollars(20), Count: 10}}
+		ctx := context.Background()
+		atm, err := pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), newStore(), journal)
+		Expect(err).To(BeNil())
+		token, err := atm.Authorize(ctx, id, pin)
+		Expect(err).To(BeNil())
+		Expect(atm.Balance(ctx, token)).To(Equal(pkg.Dollars(80)))
+		_, err = atm.Withdraw(ctx, token, pkg.Dollars(40))
+		Expect(err).To(BeNil())
+		Expect(atm.Close()).To(Succeed())
+		store, journal = restart()
+		Expect(balance(store)).To(Equal(pkg.Dollars(40)))
+	})
+})
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	TooManyConnectionsMessage = "Too many connections. Please try again later."
+	IdleTimeoutMessage        = "Connection closed after being idle for too long."
+	ShutdownMessage           = "The atm is shutting down. Goodbye."
+)
+
+// Serves the text interface over TCP, one line per command, with the same prompt loop as a local
+// terminal. Every connection gets its own session against the shared atm.
+type LineServer struct {
+	atm    Atm
+	format OutputFormat
+	// The most connections served at once; zero means no limit. Connections beyond the limit are
+	// told to try again later and closed.
+	maxConnections int
+	// How long a connection can go without sending anything before it's closed; zero means forever.
+	idleTimeout time.Duration
+}
+
+func NewLineServer(atm Atm, format OutputFormat, maxConnections int, idleTimeout time.Duration) *LineServer {
+	return &LineServer{
+		atm:            atm,
+		format:         format,
+		maxConnections: maxConnections,
+		idleTimeout:    idleTimeout,
+	}
+}
+
+// Accept connections until the context is done or accepting fails. Once the context is done, every
+// connection is told the atm is shutting down and closed, and Serve returns nil once they've all
+// finished.
+func (s *LineServer) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	connections := &sync.WaitGroup{}
+	defer connections.Wait()
+	var slots chan struct{}
+	if s.maxConnections > 0 {
+		slots = make(chan struct{}, s.maxConnections)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				_, _ = fmt.Fprintf(conn, "%s\n", TooManyConnectionsMessage)
+				conn.Close()
+				continue
+			}
+		}
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *LineServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	err := RunTerminal(ctx, s.atm, s.format, &idleReader{conn: conn, timeout: s.idleTimeout}, conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		_, _ = fmt.Fprintf(conn, "\n%s\n", IdleTimeoutMessage)
+	} else if ctx.Err() != nil {
+		_, _ = fmt.Fprintf(conn, "%s\n", ShutdownMessage)
+	}
+}
+
+// Pushes the connection's read deadline back before every read, so reads only time out once the
+// connection has been quiet for the whole timeout.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if r.timeout > 0 {
+		if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return r.conn.Read(p)
+}
@@ -0,0 +1,141 @@
+package pkg_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("LineServer", func() {
+
+	const (
+		id1  = "12345"
+		pin1 = "1234"
+		id2  = "23456"
+		pin2 = "2345"
+	)
+
+	var (
+		atm      pkg.Atm
+		listener net.Listener
+		cancel   context.CancelFunc
+		stopped  chan error
+
+		ctx       = context.Background()
+		cassettes = []pkg.Cassette{{Denomination: pkg.Dollars(20), Count: 100}}
+	)
+
+	start := func(maxConnections int, idleTimeout time.Duration) {
+		clock := pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account1 := pkg.NewAccount(clock, id1, pin1, pkg.Dollars(100), pkg.DefaultOverdraftPolicy)
+		account2 := pkg.NewAccount(clock, id2, pin2, pkg.Dollars(50), pkg.DefaultOverdraftPolicy)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account1, account2), nil)
+		Expect(err).To(BeNil())
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		var serveCtx context.Context
+		serveCtx, cancel = context.WithCancel(ctx)
+		stopped = make(chan error, 1)
+		server := pkg.NewLineServer(atm, pkg.TextFormat, maxConnections, idleTimeout)
+		go func() {
+			stopped <- server.Serve(serveCtx, listener)
+		}()
+	}
+
+	AfterEach(func() {
+		cancel()
+		Eventually(stopped).Should(Receive(BeNil()))
+		Expect(atm.Close()).To(Succeed())
+	})
+
+	connect := func() (net.Conn, *gbytes.Buffer) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).To(BeNil())
+		return conn, gbytes.BufferReader(conn)
+	}
+
+	send := func(conn net.Conn, command string) {
+		_, err := fmt.Fprintf(conn, "%s\n", command)
+		Expect(err).To(BeNil())
+	}
+
+	It("gives each connection its own session", func() {
+		start(0, 0)
+		conn1, out1 := connect()
+		defer conn1.Close()
+		conn2, out2 := connect()
+		defer conn2.Close()
+		Eventually(out1).Should(gbytes.Say("> "))
+		send(conn1, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Eventually(out1).Should(gbytes.Say(regexp.QuoteMeta(pkg.AuthorizedMessage(id1))))
+		send(conn2, fmt.Sprintf("authorize %s %s", id2, pin2))
+		Eventually(out2).Should(gbytes.Say(regexp.QuoteMeta(pkg.AuthorizedMessage(id2))))
+		send(conn1, "balance")
+		Eventually(out1).Should(gbytes.Say(regexp.QuoteMeta(pkg.BalanceMessage(pkg.Dollars(100)))))
+		send(conn2, "balance")
+		Eventually(out2).Should(gbytes.Say(regexp.QuoteMeta(pkg.BalanceMessage(pkg.Dollars(50)))))
+	})
+
+	It("logs out when the connection ends", func() {
+		start(0, 0)
+		conn, out := connect()
+		send(conn, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta(pkg.AuthorizedMessage(id1))))
+		send(conn, "end")
+		Eventually(out.Closed).Should(BeTrue())
+		conn.Close()
+		Eventually(func() error {
+			_, err := atm.Authorize(ctx, id1, pin1)
+			return err
+		}).Should(BeNil())
+	})
+
+	It("turns away connections over the limit", func() {
+		start(1, 0)
+		conn1, out1 := connect()
+		defer conn1.Close()
+		Eventually(out1).Should(gbytes.Say("> "))
+		conn2, out2 := connect()
+		defer conn2.Close()
+		Eventually(out2).Should(gbytes.Say(regexp.QuoteMeta(pkg.TooManyConnectionsMessage)))
+		Eventually(out2.Closed).Should(BeTrue())
+		conn1.Close()
+		Eventually(func() string {
+			conn3, out3 := connect()
+			defer conn3.Close()
+			Eventually(out3).Should(gbytes.Say(`\S`))
+			return string(out3.Contents())
+		}).Should(Equal("> "))
+	})
+
+	It("closes idle connections", func() {
+		start(0, 100*time.Millisecond)
+		conn, out := connect()
+		defer conn.Close()
+		send(conn, "balance")
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta(pkg.AuthorizationRequiredError.Error())))
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta(pkg.IdleTimeoutMessage)))
+		Eventually(out.Closed).Should(BeTrue())
+	})
+
+	It("says goodbye when shutting down", func() {
+		start(0, 0)
+		conn, out := connect()
+		defer conn.Close()
+		send(conn, fmt.Sprintf("authorize %s %s", id1, pin1))
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta(pkg.AuthorizedMessage(id1))))
+		cancel()
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta(pkg.ShutdownMessage)))
+		Eventually(out.Closed).Should(BeTrue())
+		_, err := atm.Authorize(ctx, id1, pin1)
+		Expect(err).To(BeNil())
+	})
+})
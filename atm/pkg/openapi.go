@@ -0,0 +1,193 @@
+package pkg
+
+// The OpenAPI description of the JSON API served by NewServer. Keep it in step with server.go.
+const OpenApiDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "ATM",
+    "version": "1.0.0",
+    "description": "Drive an atm over HTTP. Start a session with /authorize, then pass its token as \"Authorization: Bearer <token>\" on every other request. Amounts are objects with a decimal string and a currency code, such as {\"amount\": \"40.00\", \"currency\": \"USD\"}. Requests may leave the currency out to use the account's."
+  },
+  "paths": {
+    "/authorize": {
+      "post": {
+        "summary": "Start a session for an account.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AuthorizeRequest"}}}
+        },
+        "responses": {
+          "200": {"description": "Authorized.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AuthorizeResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "409": {"description": "The account is already logged in.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/withdraw": {
+      "post": {
+        "summary": "Withdraw cash. Less than requested may be dispensed if the atm runs short.",
+        "security": [{"session": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AmountRequest"}}}
+        },
+        "responses": {
+          "200": {"description": "Cash dispensed.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WithdrawResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "422": {"description": "The account can't cover the withdrawal.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/deposit": {
+      "post": {
+        "summary": "Deposit money.",
+        "security": [{"session": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AmountRequest"}}}
+        },
+        "responses": {
+          "200": {"description": "Deposited. Returns the new balance.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BalanceResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/balance": {
+      "get": {
+        "summary": "Get the account's balance.",
+        "security": [{"session": []}],
+        "responses": {
+          "200": {"description": "The balance.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BalanceResponse"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/history": {
+      "get": {
+        "summary": "List the account's transactions, newest first.",
+        "security": [{"session": []}],
+        "responses": {
+          "200": {"description": "The history.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/HistoryResponse"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/logout": {
+      "post": {
+        "summary": "End the session.",
+        "security": [{"session": []}],
+        "responses": {
+          "200": {"description": "Logged out.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LogoutResponse"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "session": {"type": "http", "scheme": "bearer", "description": "The token returned by /authorize."}
+    },
+    "responses": {
+      "BadRequest": {"description": "The request or amount is invalid.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "Authorization failed, or the session token is missing, unknown or expired.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unavailable": {"description": "The atm can't do this right now: it's out of cash, the dispenser failed, or it's shutting down.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Decimal": {"type": "string", "pattern": "^-?[0-9]*(\\.[0-9]{0,3})?$", "example": "40.00"},
+      "Amount": {
+        "type": "object",
+        "properties": {"amount": {"$ref": "#/components/schemas/Decimal"}, "currency": {"$ref": "#/components/schemas/Currency"}},
+        "example": {"amount": "40.00", "currency": "USD"}
+      },
+      "Currency": {"type": "string", "description": "An ISO 4217 currency code.", "example": "EUR"},
+      "AuthorizeRequest": {
+        "type": "object",
+        "required": ["id", "pin"],
+        "properties": {"id": {"type": "string"}, "pin": {"type": "string"}}
+      },
+      "AuthorizeResponse": {
+        "type": "object",
+        "properties": {"token": {"type": "string"}, "account_id": {"type": "string"}}
+      },
+      "AmountRequest": {
+        "type": "object",
+        "required": ["amount"],
+        "properties": {
+          "amount": {"$ref": "#/components/schemas/Decimal"},
+          "currency": {"$ref": "#/components/schemas/Currency", "description": "For withdrawals of foreign cash. Defaults to the account's currency."}
+        }
+      },
+      "BalanceResponse": {
+        "type": "object",
+        "properties": {"balance": {"$ref": "#/components/schemas/Amount"}}
+      },
+      "WithdrawResponse": {
+        "type": "object",
+        "properties": {
+          "requested": {"$ref": "#/components/schemas/Amount"},
+          "dispensed": {"$ref": "#/components/schemas/Amount"},
+          "balance": {"$ref": "#/components/schemas/Amount"},
+          "transaction": {"$ref": "#/components/schemas/Transaction"}
+        }
+      },
+      "HistoryResponse": {
+        "type": "object",
+        "properties": {"transactions": {"type": "array", "items": {"$ref": "#/components/schemas/Transaction"}}}
+      },
+      "LogoutResponse": {
+        "type": "object",
+        "properties": {"account_id": {"type": "string"}}
+      },
+      "Transaction": {
+        "type": "object",
+        "properties": {
+          "version": {"type": "integer", "description": "The version of the schema the transaction is written in.", "example": 1},
+          "id": {"type": "string"},
+          "type": {"type": "string", "enum": ["withdrawal", "deposit", "fee", "transfer", "reversal", "interest"]},
+          "parent_id": {"type": "string"},
+          "terminal_id": {"type": "string"},
+          "memo": {"type": "string"},
+          "date": {"type": "string", "format": "date-time"},
+          "amount": {"$ref": "#/components/schemas/Amount"},
+          "balance": {"$ref": "#/components/schemas/Amount"},
+          "overdraft": {"type": "boolean"},
+          "dispensed": {"type": "array", "items": {"$ref": "#/components/schemas/Notes"}},
+          "conversion": {"$ref": "#/components/schemas/Conversion"},
+          "fees": {"type": "array", "items": {"$ref": "#/components/schemas/Transaction"}},
+          "reversals": {"type": "array", "items": {"$ref": "#/components/schemas/Transaction"}}
+        }
+      },
+      "Conversion": {
+        "type": "object",
+        "description": "Foreign cash dispensed by a withdrawal. The transaction's amount is what it cost in the account's currency.",
+        "properties": {
+          "foreign": {"$ref": "#/components/schemas/Amount"},
+          "home": {"$ref": "#/components/schemas/Amount"},
+          "rate": {"type": "string", "description": "Units of the account's currency per unit of the foreign currency, spread included.", "example": "1.0873"}
+        }
+      },
+      "Notes": {
+        "type": "object",
+        "properties": {"denomination": {"$ref": "#/components/schemas/Amount"}, "count": {"type": "integer"}}
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "string", "description": "What to tell the customer."},
+          "code": {"type": "string", "description": "A stable code for the error, such as \"no_money\"."},
+          "category": {"type": "string", "enum": ["auth", "funds", "device", "input", "internal"]}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,113 @@
+package pkg
+
+var (
+	// The policy accounts had before policies were configurable: a single overdraft of any size is
+	// allowed and charged a flat $5, after which withdrawals are blocked until the account is back
+	// in the black.
+	DefaultOverdraftPolicy = OverdraftPolicy{
+		Fees: FlatFee(Dollars(5)),
+	}
+)
+
+// Rules for withdrawals that take an account below zero.
+type OverdraftPolicy struct {
+	// Decline any withdrawal that would overdraw the account by more than the grace amount.
+	Decline bool
+	// Allow further withdrawals while the account is already overdrawn (up to the limit).
+	AllowWhileOverdrawn bool
+	// How far below zero the balance may go. A zero limit means there is no limit.
+	Limit Amount
+	// Overdrafts that leave the balance no more than this far below zero aren't charged a fee.
+	Grace Amount
+	// The fee charged for each overdraft. A nil schedule means overdrafts are free.
+	Fees FeeSchedule
+	// The most that will be charged in overdraft fees on a single day. Zero means no cap.
+	DailyCap Amount
+}
+
+// Check a withdrawal of amount (a negative value) against the current balance, given the overdraft
+// fees already charged today. Returns the fee to charge, or an error if the withdrawal is declined.
+func (p OverdraftPolicy) Charge(balance, amount, chargedToday Amount) (Amount, error) {
+	after := balance.Add(amount)
+	if !ZeroAmount.GreaterThan(amount) || !ZeroAmount.GreaterThan(after) {
+		return ZeroAmount, nil
+	}
+	if ZeroAmount.GreaterThan(balance) && !p.AllowWhileOverdrawn {
+		return ZeroAmount, AccountOverdrawnError
+	}
+	overdrawn := after.Negative()
+	if !overdrawn.GreaterThan(p.Grace) {
+		return ZeroAmount, nil
+	}
+	if p.Decline {
+		return ZeroAmount, InsufficientFundsError
+	}
+	if p.Limit.GreaterThan(ZeroAmount) && overdrawn.GreaterThan(p.Limit) {
+		return ZeroAmount, OverdraftLimitError
+	}
+	if p.Fees == nil {
+		return ZeroAmount, nil
+	}
+	fee := p.Fees.Fee(overdrawn)
+	if p.DailyCap.GreaterThan(ZeroAmount) && chargedToday.Add(fee).GreaterThan(p.DailyCap) {
+		fee = p.DailyCap.Subtract(chargedToday)
+		if ZeroAmount.GreaterThan(fee) {
+			fee = ZeroAmount
+		}
+	}
+	return fee, nil
+}
+
+// A CurrencyMismatchError if any of the policy's amounts aren't in the given currency. An account
+// in another currency needs its own policy, since the default one charges dollars.
+func (p OverdraftPolicy) checkCurrency(currency Currency) error {
+	amounts := []Amount{p.Limit, p.Grace, p.DailyCap}
+	switch fees := p.Fees.(type) {
+	case FlatFee:
+		amounts = append(amounts, Amount(fees))
+	case TieredFee:
+		for _, tier := range fees {
+			amounts = append(amounts, tier.Over, tier.Fee)
+		}
+	}
+	for _, amount := range amounts {
+		if !Zero(currency).SameCurrency(amount) {
+			return currencyMismatchError(currency, amount.Currency())
+		}
+	}
+	return nil
+}
+
+type FeeSchedule interface {
+	// The fee for an overdraft that leaves the account overdrawn by the given (positive) amount.
+	Fee(overdrawn Amount) Amount
+}
+
+// The same fee no matter how large the overdraft is.
+type FlatFee Amount
+
+func (f FlatFee) Fee(overdrawn Amount) Amount {
+	return Amount(f)
+}
+
+// A fee tier applies once an account is overdrawn by more than Over.
+type FeeTier struct {
+	Over Amount
+	Fee  Amount
+}
+
+// Fees that step up with the size of the overdraft. The highest tier the overdraft falls into is
+// charged; overdrafts below every tier are free.
+type TieredFee []FeeTier
+
+func (t TieredFee) Fee(overdrawn Amount) Amount {
+	fee := ZeroAmount
+	over := ZeroAmount
+	for _, tier := range t {
+		if overdrawn.GreaterThan(tier.Over) && !over.GreaterThan(tier.Over) {
+			fee = tier.Fee
+			over = tier.Over
+		}
+	}
+	return fee
+}
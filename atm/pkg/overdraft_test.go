@@ -0,0 +1,56 @@
+package pkg_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("OverdraftPolicy", func() {
+
+	It("doesn't charge withdrawals that stay in the black", func() {
+		fee, err := pkg.DefaultOverdraftPolicy.Charge(pkg.Dollars(100), pkg.Dollars(-100), pkg.ZeroAmount)
+		Expect(err).To(BeNil())
+		Expect(fee).To(Equal(pkg.ZeroAmount))
+	})
+
+	It("allows a single overdraft by default", func() {
+		fee, err := pkg.DefaultOverdraftPolicy.Charge(pkg.Dollars(100), pkg.Dollars(-500), pkg.ZeroAmount)
+		Expect(err).To(BeNil())
+		Expect(fee).To(Equal(pkg.Dollars(5)))
+		_, err = pkg.DefaultOverdraftPolicy.Charge(pkg.Dollars(-400), pkg.Dollars(-20), pkg.Dollars(5))
+		Expect(err).To(Equal(pkg.AccountOverdrawnError))
+	})
+
+	It("declines overdrafts beyond the grace amount", func() {
+		policy := pkg.OverdraftPolicy{Decline: true, Grace: pkg.Dollars(10)}
+		fee, err := policy.Charge(pkg.Dollars(50), pkg.Dollars(-60), pkg.ZeroAmount)
+		Expect(err).To(BeNil())
+		Expect(fee).To(Equal(pkg.ZeroAmount))
+		_, err = policy.Charge(pkg.Dollars(50), pkg.Dollars(-80), pkg.ZeroAmount)
+		Expect(err).To(Equal(pkg.InsufficientFundsError))
+	})
+
+	It("enforces the overdraft limit", func() {
+		policy := pkg.OverdraftPolicy{AllowWhileOverdrawn: true, Limit: pkg.Dollars(100), Fees: pkg.FlatFee(pkg.Dollars(5))}
+		fee, err := policy.Charge(pkg.Dollars(-40), pkg.Dollars(-60), pkg.Dollars(5))
+		Expect(err).To(BeNil())
+		Expect(fee).To(Equal(pkg.Dollars(5)))
+		_, err = policy.Charge(pkg.Dollars(-40), pkg.Dollars(-80), pkg.Dollars(5))
+		Expect(err).To(Equal(pkg.OverdraftLimitError))
+	})
+
+	It("charges tiered fees", func() {
+		policy := pkg.OverdraftPolicy{Fees: pkg.TieredFee{
+			{Over: pkg.Dollars(100), Fee: pkg.Dollars(35)},
+			{Over: pkg.ZeroAmount, Fee: pkg.Dollars(10)},
+			{Over: pkg.Dollars(500), Fee: pkg.Dollars(50)},
+		}}
+		fee, _ := policy.Charge(pkg.ZeroAmount, pkg.Dollars(-20), pkg.ZeroAmount)
+		Expect(fee).To(Equal(pkg.Dollars(10)))
+		fee, _ = policy.Charge(pkg.ZeroAmount, pkg.Dollars(-120), pkg.ZeroAmount)
+		Expect(fee).To(Equal(pkg.Dollars(35)))
+		fee, _ = policy.Charge(pkg.ZeroAmount, pkg.Dollars(-520), pkg.ZeroAmount)
+		Expect(fee).To(Equal(pkg.Dollars(50)))
+	})
+})
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"encoding/json"
+)
+
+type OutputFormat string
+
+const (
+	// Render responses as the prose the customer reads at a terminal.
+	TextFormat OutputFormat = "text"
+	// Render responses as single line JSON objects, for scripts.
+	JsonFormat OutputFormat = "json"
+)
+
+type ResponseStatus string
+
+const (
+	ResponseOk    ResponseStatus = "ok"
+	ResponseError ResponseStatus = "error"
+	// Something the customer should know that isn't the reply to a command, such as a timeout warning.
+	ResponseNotice ResponseStatus = "notice"
+)
+
+// The outcome of a text interface command, or a notice about the session. Only the fields that
+// apply to the command are set.
+type Response struct {
+	Command string
+	Status  ResponseStatus
+	// Set when the status is ResponseError.
+	ErrorCode     string
+	ErrorCategory ErrorCategory
+	// Set when the status is ResponseNotice.
+	Event SessionEventType
+	// What the customer reads in the text format.
+	Message   string
+	AccountId string
+	Balance   *Amount
+	// For withdrawals, the amount asked for and the amount that actually came out.
+	Requested   *Amount
+	Dispensed   *Amount
+	Transaction *Transaction
+	// The fees charged by the command that haven't been refunded.
+	Fees []Transaction
+	// The account's history, newest first.
+	Transactions []Transaction
+}
+
+// The customer is only shown the error's message, as given by UserMessage.
+func NewErrorResponse(command string, err error) Response {
+	atmErr := AsATMError(err)
+	return Response{
+		Command:       command,
+		Status:        ResponseError,
+		ErrorCode:     atmErr.Code,
+		ErrorCategory: atmErr.Category,
+		Message:       atmErr.Message,
+	}
+}
+
+func NewUsageResponse(command, usage string) Response {
+	return NewErrorResponse(command, UsageError.WithMessage(usage))
+}
+
+func (r Response) Render(format OutputFormat) string {
+	if format == JsonFormat {
+		data, err := json.Marshal(r)
+		if err != nil {
+			data, _ = json.Marshal(NewErrorResponse(r.Command, InternalError.WithCause(err)))
+		}
+		return string(data)
+	}
+	return r.Message
+}
+
+type responseRecord struct {
+	Command       string           `json:"command,omitempty"`
+	Status        ResponseStatus   `json:"status"`
+	ErrorCode     string           `json:"error_code,omitempty"`
+	ErrorCategory ErrorCategory    `json:"error_category,omitempty"`
+	Event         SessionEventType `json:"event,omitempty"`
+	Message       string           `json:"message"`
+	AccountId     string           `json:"account_id,omitempty"`
+	Balance       *Amount          `json:"balance,omitempty"`
+	Requested     *Amount          `json:"requested,omitempty"`
+	Dispensed     *Amount          `json:"dispensed,omitempty"`
+	Transaction   *Transaction     `json:"transaction,omitempty"`
+	Fees          []Transaction    `json:"fees,omitempty"`
+	Transactions  []Transaction    `json:"transactions,omitempty"`
+}
+
+// Amounts are written with their currency, and transactions in the schema described on
+// SchemaVersion, as in the HTTP API.
+func (r Response) MarshalJSON() ([]byte, error) {
+	record := responseRecord{
+		Command:       r.Command,
+		Status:        r.Status,
+		ErrorCode:     r.ErrorCode,
+		ErrorCategory: r.ErrorCategory,
+		Event:         r.Event,
+		Message:       r.Message,
+		AccountId:     r.AccountId,
+		Balance:       r.Balance,
+		Requested:     r.Requested,
+		Dispensed:     r.Dispensed,
+		Transaction:   r.Transaction,
+		Fees:          r.Fees,
+		Transactions:  r.Transactions,
+	}
+	return json.Marshal(record)
+}
@@ -0,0 +1,65 @@
+package pkg_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Response", func() {
+
+	decode := func(rendered string) map[string]interface{} {
+		var decoded map[string]interface{}
+		Expect(json.Unmarshal([]byte(rendered), &decoded)).To(Succeed())
+		return decoded
+	}
+
+	It("renders text as the message", func() {
+		response := pkg.NewErrorResponse("balance", pkg.AuthorizationRequiredError)
+		Expect(response.Render(pkg.TextFormat)).To(Equal(pkg.AuthorizationRequiredError.Error()))
+	})
+
+	It("gives errors stable codes", func() {
+		Expect(pkg.NewErrorResponse("withdraw", pkg.NoMoneyError).ErrorCode).To(Equal("no_money"))
+		Expect(pkg.NewErrorResponse("withdraw", context.DeadlineExceeded).ErrorCode).To(Equal("timeout"))
+		Expect(pkg.NewErrorResponse("withdraw", errors.New("disk full")).ErrorCode).To(Equal(pkg.InternalError.Code))
+		Expect(pkg.NewUsageResponse("", pkg.HelpMessage).ErrorCode).To(Equal(pkg.UsageError.Code))
+	})
+
+	It("renders JSON on a single line", func() {
+		balance := pkg.NewAmount(10, 24)
+		response := pkg.Response{
+			Command: "balance",
+			Status:  pkg.ResponseOk,
+			Message: pkg.BalanceMessage(balance),
+			Balance: &balance,
+		}
+		rendered := response.Render(pkg.JsonFormat)
+		Expect(rendered).NotTo(ContainSubstring("\n"))
+		Expect(decode(rendered)).To(Equal(map[string]interface{}{
+			"command": "balance",
+			"status":  "ok",
+			"message": "Current balance: $10.24",
+			"balance": map[string]interface{}{"amount": "10.24", "currency": "USD"},
+		}))
+	})
+
+	It("renders transactions", func() {
+		clock := pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		txn := pkg.NewTransaction(clock, pkg.Dollars(-20), pkg.Dollars(80), pkg.TransactionDetails{TerminalId: "ATM-TEST"})
+		response := pkg.Response{Status: pkg.ResponseOk, Transactions: []pkg.Transaction{txn}}
+		transactions := decode(response.Render(pkg.JsonFormat))["transactions"].([]interface{})
+		Expect(transactions).To(HaveLen(1))
+		Expect(transactions[0]).To(HaveKeyWithValue("version", float64(pkg.SchemaVersion)))
+		Expect(transactions[0]).To(HaveKeyWithValue("id", txn.Id))
+		Expect(transactions[0]).To(HaveKeyWithValue("type", "withdrawal"))
+		Expect(transactions[0]).To(HaveKeyWithValue("amount", map[string]interface{}{"amount": "-20.00", "currency": "USD"}))
+		Expect(transactions[0]).To(HaveKeyWithValue("balance", map[string]interface{}{"amount": "80.00", "currency": "USD"}))
+		Expect(transactions[0]).To(HaveKeyWithValue("date", "2020-08-14T12:00:00Z"))
+	})
+})
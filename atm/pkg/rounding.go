@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"math/big"
+)
+
+// How to round a result that falls between two minor units, such as a converted amount.
+type RoundingMode string
+
+const (
+	// Round to the nearest unit, and halves away from zero. The default.
+	RoundHalfUp RoundingMode = "half_up"
+	// Round to the nearest unit, and halves to the even one, so rounding a long run of halves
+	// doesn't drift up. Also known as banker's rounding.
+	RoundHalfEven RoundingMode = "half_even"
+	// Round towards negative infinity.
+	RoundFloor RoundingMode = "floor"
+	// Round towards positive infinity.
+	RoundCeiling RoundingMode = "ceiling"
+)
+
+// The quotient n/d rounded to a whole number. The denominator must be positive.
+func (m RoundingMode) divide(n, d *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	// QuoRem truncates towards zero, so the remainder has the sign of the numerator.
+	switch m {
+	case RoundFloor:
+		if r.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		}
+	case RoundCeiling:
+		if r.Sign() > 0 {
+			q.Add(q, big.NewInt(1))
+		}
+	default:
+		twice := new(big.Int).Abs(r)
+		twice.Lsh(twice, 1)
+		half := twice.Cmp(d)
+		if half > 0 || (half == 0 && (m != RoundHalfEven || q.Bit(0) == 1)) {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+	return q
+}
@@ -0,0 +1,322 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Transactions and account snapshots have a stable JSON form, for sending over an API or keeping
+// outside this package; the HTTP API, the JSON output and the stores all use it. Every document
+// says which version of the schema it was written in, and is only read by versions of this package
+// that know that version; fields are only ever added within a version, so readers ignore fields
+// they don't know.
+//
+// Amounts are written as by Amount's MarshalJSON. A transaction, version 1:
+//
+//	{
+//	  "version": 1,
+//	  "id": "3f2a9c0d1e4b5a68",
+//	  "type": "withdrawal",                 // withdrawal, deposit, fee, transfer, reversal or interest
+//	  "parent_id": "...",                   // optional: the transaction a fee or reversal belongs to
+//	  "terminal_id": "ATM-0001",            // optional
+//	  "memo": "...",                        // optional
+//	  "date": "2020-08-14T12:00:00Z",       // RFC 3339
+//	  "amount": {"amount": "-111.45", "currency": "USD"},
+//	  "balance": {"amount": "888.55", "currency": "USD"},
+//	  "overdraft": false,
+//	  "dispensed": [                        // optional: the notes paid out
+//	    {"denomination": {"amount": "50.00", "currency": "EUR"}, "count": 2}
+//	  ],
+//	  "conversion": {                       // optional: for foreign cash
+//	    "foreign": {"amount": "100.00", "currency": "EUR"},
+//	    "home": {"amount": "111.45", "currency": "USD"},
+//	    "rate": "1.1144825"                 // home currency per unit of foreign
+//	  },
+//	  "fees": [...],                        // optional: transactions, as Transaction.Fees
+//	  "reversals": [...]                    // optional: transactions, as Transaction.Reversals
+//	}
+//
+// An account snapshot, version 1:
+//
+//	{
+//	  "version": 1,
+//	  "id": "12345",
+//	  "currency": "USD",
+//	  "balance": {"amount": "888.55", "currency": "USD"},
+//	  "overdraft_policy": {
+//	    "decline": false,
+//	    "allow_while_overdrawn": false,
+//	    "limit": {"amount": "100.00", "currency": "USD"},
+//	    "grace": {"amount": "0.00"},
+//	    "flat_fee": {"amount": "5.00", "currency": "USD"},               // optional
+//	    "fee_tiers": [{"over": {...}, "fee": {...}}],                   // optional, instead of flat_fee
+//	    "daily_cap": {"amount": "0.00"}
+//	  },
+//	  "history": [...]                      // transactions, oldest first
+//	}
+//
+// Snapshots never carry the PIN. The stores keep the same document with "pin_hash" added, the
+// account's AccountSnapshot.PinHash, and nothing outside them reads it.
+const SchemaVersion = 1
+
+var (
+	SchemaVersionError = NewATMError("unsupported_schema_version", InputCategory, "The data was written in an unsupported format.")
+
+	_ json.Marshaler   = Transaction{}
+	_ json.Unmarshaler = new(Transaction)
+	_ json.Marshaler   = AccountSnapshot{}
+	_ json.Unmarshaler = new(AccountSnapshot)
+)
+
+func checkSchemaVersion(version int) error {
+	if version < 1 || version > SchemaVersion {
+		return SchemaVersionError.WithDetail(fmt.Sprintf("(version %d)", version))
+	}
+	return nil
+}
+
+type transactionJSON struct {
+	Version    int             `json:"version"`
+	Id         string          `json:"id"`
+	Type       TransactionType `json:"type"`
+	ParentId   string          `json:"parent_id,omitempty"`
+	TerminalId string          `json:"terminal_id,omitempty"`
+	Memo       string          `json:"memo,omitempty"`
+	Date       time.Time       `json:"date"`
+	Amount     Amount          `json:"amount"`
+	Balance    Amount          `json:"balance"`
+	Overdraft  bool            `json:"overdraft"`
+	Dispensed  []notesJSON     `json:"dispensed,omitempty"`
+	Conversion *conversionJSON `json:"conversion,omitempty"`
+	Fees       []Transaction   `json:"fees,omitempty"`
+	Reversals  []Transaction   `json:"reversals,omitempty"`
+}
+
+type notesJSON struct {
+	Denomination Amount `json:"denomination"`
+	Count        int    `json:"count"`
+}
+
+type conversionJSON struct {
+	Foreign Amount `json:"foreign"`
+	Home    Amount `json:"home"`
+	Rate    string `json:"rate"`
+}
+
+// Write the transaction in the current version of the schema.
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	record := transactionJSON{
+		Version:    SchemaVersion,
+		Id:         t.Id,
+		Type:       t.Type,
+		ParentId:   t.ParentId,
+		TerminalId: t.TerminalId,
+		Memo:       t.Memo,
+		Date:       t.Date,
+		Amount:     t.Amount,
+		Balance:    t.Balance,
+		Overdraft:  t.Overdraft,
+		Fees:       t.Fees,
+		Reversals:  t.Reversals,
+	}
+	for _, cassette := range t.Dispensed {
+		record.Dispensed = append(record.Dispensed, notesJSON{Denomination: cassette.Denomination, Count: cassette.Count})
+	}
+	if conversion := t.Conversion; conversion != nil {
+		record.Conversion = &conversionJSON{
+			Foreign: conversion.Foreign,
+			Home:    conversion.Home,
+			Rate:    conversion.Rate.String(),
+		}
+	}
+	return json.Marshal(record)
+}
+
+// Read a transaction written by MarshalJSON. Fails with SchemaVersionError if it was written in a
+// version of the schema this package doesn't know.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var record transactionJSON
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(record.Version); err != nil {
+		return err
+	}
+	txn := Transaction{
+		Id:         record.Id,
+		Type:       record.Type,
+		ParentId:   record.ParentId,
+		TerminalId: record.TerminalId,
+		Memo:       record.Memo,
+		Date:       record.Date,
+		Amount:     record.Amount,
+		Balance:    record.Balance,
+		Overdraft:  record.Overdraft,
+		Fees:       record.Fees,
+		Reversals:  record.Reversals,
+	}
+	for _, notes := range record.Dispensed {
+		txn.Dispensed = append(txn.Dispensed, Cassette{Denomination: notes.Denomination, Count: notes.Count})
+	}
+	if conversion := record.Conversion; conversion != nil {
+		rate, err := ParseRate(conversion.Foreign.Currency(), conversion.Home.Currency(), conversion.Rate)
+		if err != nil {
+			return fmt.Errorf("reading transaction %s: %w", record.Id, err)
+		}
+		txn.Conversion = &Conversion{Foreign: conversion.Foreign, Home: conversion.Home, Rate: rate}
+	}
+	*t = txn
+	return nil
+}
+
+type snapshotJSON struct {
+	Version  int           `json:"version"`
+	Id       string        `json:"id"`
+	Currency Currency      `json:"currency"`
+	Balance  Amount        `json:"balance"`
+	Policy   policyJSON    `json:"overdraft_policy"`
+	History  []Transaction `json:"history"`
+}
+
+type policyJSON struct {
+	Decline             bool          `json:"decline"`
+	AllowWhileOverdrawn bool          `json:"allow_while_overdrawn"`
+	Limit               Amount        `json:"limit"`
+	Grace               Amount        `json:"grace"`
+	FlatFee             *Amount       `json:"flat_fee,omitempty"`
+	FeeTiers            []feeTierJSON `json:"fee_tiers,omitempty"`
+	DailyCap            Amount        `json:"daily_cap"`
+}
+
+type feeTierJSON struct {
+	Over Amount `json:"over"`
+	Fee  Amount `json:"fee"`
+}
+
+// Write the snapshot in the current version of the schema, without its PIN hash. Fails if the
+// policy has a fee schedule other than a FlatFee or TieredFee, which the schema has no way to write.
+func (s AccountSnapshot) MarshalJSON() ([]byte, error) {
+	record, err := newSnapshotJSON(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(record)
+}
+
+// Read a snapshot written by MarshalJSON. Fails with SchemaVersionError if it was written in a
+// version of the schema this package doesn't know.
+func (s *AccountSnapshot) UnmarshalJSON(data []byte) error {
+	var record snapshotJSON
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	snapshot, err := record.snapshot()
+	if err != nil {
+		return err
+	}
+	*s = snapshot
+	return nil
+}
+
+func newSnapshotJSON(snapshot AccountSnapshot) (snapshotJSON, error) {
+	policy, err := newPolicyJSON(snapshot.Policy)
+	if err != nil {
+		return snapshotJSON{}, fmt.Errorf("saving account %s: %w", snapshot.Id, err)
+	}
+	history := snapshot.History
+	if history == nil {
+		history = []Transaction{}
+	}
+	return snapshotJSON{
+		Version:  SchemaVersion,
+		Id:       snapshot.Id,
+		Currency: snapshot.Currency,
+		Balance:  snapshot.Balance,
+		Policy:   policy,
+		History:  history,
+	}, nil
+}
+
+func (r snapshotJSON) snapshot() (AccountSnapshot, error) {
+	if err := checkSchemaVersion(r.Version); err != nil {
+		return AccountSnapshot{}, err
+	}
+	return AccountSnapshot{
+		Id:       r.Id,
+		Currency: r.Currency,
+		Balance:  r.Balance,
+		Policy:   r.Policy.policy(),
+		History:  r.History,
+	}, nil
+}
+
+// An account as the stores keep it: its snapshot with the PIN hash added.
+type storedAccountJSON struct {
+	snapshotJSON
+	PinHash string `json:"pin_hash"`
+}
+
+func newStoredAccountJSON(snapshot AccountSnapshot) (storedAccountJSON, error) {
+	record, err := newSnapshotJSON(snapshot)
+	if err != nil {
+		return storedAccountJSON{}, err
+	}
+	return storedAccountJSON{snapshotJSON: record, PinHash: snapshot.PinHash}, nil
+}
+
+// The stored snapshot, checked with Validate so it can be restored without panicking.
+func (r storedAccountJSON) snapshot() (AccountSnapshot, error) {
+	snapshot, err := r.snapshotJSON.snapshot()
+	if err != nil {
+		return AccountSnapshot{}, fmt.Errorf("reading account %s: %w", r.Id, err)
+	}
+	snapshot.PinHash = r.PinHash
+	if err := snapshot.Validate(); err != nil {
+		return AccountSnapshot{}, fmt.Errorf("reading account %s: %w", r.Id, err)
+	}
+	return snapshot, nil
+}
+
+func newPolicyJSON(policy OverdraftPolicy) (policyJSON, error) {
+	record := policyJSON{
+		Decline:             policy.Decline,
+		AllowWhileOverdrawn: policy.AllowWhileOverdrawn,
+		Limit:               policy.Limit,
+		Grace:               policy.Grace,
+		DailyCap:            policy.DailyCap,
+	}
+	switch fees := policy.Fees.(type) {
+	case nil:
+	case FlatFee:
+		fee := Amount(fees)
+		record.FlatFee = &fee
+	case TieredFee:
+		for _, tier := range fees {
+			record.FeeTiers = append(record.FeeTiers, feeTierJSON{Over: tier.Over, Fee: tier.Fee})
+		}
+	default:
+		return policyJSON{}, fmt.Errorf("unsupported fee schedule %T", fees)
+	}
+	return record, nil
+}
+
+func (r policyJSON) policy() OverdraftPolicy {
+	policy := OverdraftPolicy{
+		Decline:             r.Decline,
+		AllowWhileOverdrawn: r.AllowWhileOverdrawn,
+		Limit:               r.Limit,
+		Grace:               r.Grace,
+		DailyCap:            r.DailyCap,
+	}
+	if r.FlatFee != nil {
+		policy.Fees = FlatFee(*r.FlatFee)
+	} else if len(r.FeeTiers) > 0 {
+		tiers := make(TieredFee, 0, len(r.FeeTiers))
+		for _, tier := range r.FeeTiers {
+			tiers = append(tiers, FeeTier{Over: tier.Over, Fee: tier.Fee})
+		}
+		policy.Fees = tiers
+	}
+	return policy
+}
@@ -0,0 +1,147 @@
+package pkg_test
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Schema", func() {
+	var (
+		clock   *pkg.ManualClock
+		account pkg.Account
+	)
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account = pkg.NewAccount(clock, "12345", "1234", pkg.Dollars(100), pkg.OverdraftPolicy{
+			Limit: pkg.Dollars(500),
+			Fees:  pkg.TieredFee{{Over: pkg.ZeroAmount, Fee: pkg.Dollars(5)}},
+		})
+	})
+
+	Context("transactions", func() {
+		It("writes the documented form", func() {
+			rate, err := pkg.ParseRate(pkg.EUR, pkg.USD, "1.1144825")
+			Expect(err).To(BeNil())
+			txn := pkg.Transaction{
+				Id:         "3f2a9c0d1e4b5a68",
+				Type:       pkg.WithdrawalTransaction,
+				TerminalId: "ATM-0001",
+				Date:       clock.Now(),
+				Amount:     pkg.NewAmount(-111, -45),
+				Balance:    pkg.NewAmount(888, 55),
+				Dispensed:  pkg.Cassettes{{Denomination: pkg.MajorAmount(pkg.EUR, 50), Count: 2}},
+				Conversion: &pkg.Conversion{Foreign: pkg.MajorAmount(pkg.EUR, 100), Home: pkg.NewAmount(111, 45), Rate: rate},
+			}
+			Expect(json.Marshal(txn)).To(MatchJSON(`{
+				"version": 1,
+				"id": "3f2a9c0d1e4b5a68",
+				"type": "withdrawal",
+				"terminal_id": "ATM-0001",
+				"date": "2020-08-14T12:00:00Z",
+				"amount": {"amount": "-111.45", "currency": "USD"},
+				"balance": {"amount": "888.55", "currency": "USD"},
+				"overdraft": false,
+				"dispensed": [{"denomination": {"amount": "50.00", "currency": "EUR"}, "count": 2}],
+				"conversion": {
+					"foreign": {"amount": "100.00", "currency": "EUR"},
+					"home": {"amount": "111.45", "currency": "USD"},
+					"rate": "1.1144825"
+				}
+			}`))
+
+			var decoded pkg.Transaction
+			data, err := json.Marshal(txn)
+			Expect(err).To(BeNil())
+			Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(txn))
+		})
+
+		It("round-trips fees along with the transaction", func() {
+			txn, err := account.Transaction(pkg.Dollars(-200), pkg.TransactionDetails{TerminalId: "ATM-0001"})
+			Expect(err).To(BeNil())
+			Expect(txn.Fees).To(HaveLen(1))
+			data, err := json.Marshal(txn)
+			Expect(err).To(BeNil())
+			var decoded pkg.Transaction
+			Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(*txn))
+		})
+
+		It("refuses versions it doesn't know", func() {
+			var decoded pkg.Transaction
+			Expect(json.Unmarshal([]byte(`{"id": "1", "type": "deposit"}`), &decoded)).To(MatchError(pkg.SchemaVersionError))
+			Expect(json.Unmarshal([]byte(`{"version": 2, "id": "1", "type": "deposit"}`), &decoded)).To(MatchError(pkg.SchemaVersionError))
+		})
+	})
+
+	Context("account snapshots", func() {
+		It("round-trips", func() {
+			_, err := account.Transaction(pkg.Dollars(-200), pkg.TransactionDetails{})
+			Expect(err).To(BeNil())
+			_, err = account.Transaction(pkg.Dollars(50), pkg.TransactionDetails{Memo: "payday"})
+			Expect(err).To(BeNil())
+			snapshot := account.Snapshot()
+			data, err := json.Marshal(snapshot)
+			Expect(err).To(BeNil())
+			var decoded pkg.AccountSnapshot
+			Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+			Expect(decoded.PinHash).To(BeEmpty())
+			decoded.PinHash = snapshot.PinHash
+			Expect(decoded).To(Equal(snapshot))
+
+			restored := pkg.RestoreAccount(clock, decoded)
+			Expect(restored.Balance()).To(Equal(pkg.Dollars(-55)))
+			Expect(restored.History()).To(HaveLen(3))
+		})
+
+		It("leaves out the PIN", func() {
+			snapshot := account.Snapshot()
+			Expect(snapshot.PinHash).NotTo(BeEmpty())
+			Expect(snapshot.PinHash).NotTo(ContainSubstring("1234"))
+			data, err := json.Marshal(snapshot)
+			Expect(err).To(BeNil())
+			Expect(string(data)).NotTo(ContainSubstring("pin"))
+
+			var decoded pkg.AccountSnapshot
+			Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+			Expect(pkg.RestoreAccount(clock, decoded).Authorize("1234")).To(BeFalse())
+		})
+
+		It("writes flat fees and empty histories", func() {
+			snapshot := pkg.NewAccount(clock, "1", "1234", pkg.Zero(pkg.JPY), pkg.OverdraftPolicy{
+				Fees: pkg.FlatFee(pkg.MajorAmount(pkg.JPY, 500)),
+			}).Snapshot()
+			data, err := json.Marshal(snapshot)
+			Expect(err).To(BeNil())
+			Expect(data).To(MatchJSON(`{
+				"version": 1,
+				"id": "1",
+				"currency": "JPY",
+				"balance": {"amount": "0", "currency": "JPY"},
+				"overdraft_policy": {
+					"decline": false,
+					"allow_while_overdrawn": false,
+					"limit": {"amount": "0.00"},
+					"grace": {"amount": "0.00"},
+					"flat_fee": {"amount": "500", "currency": "JPY"},
+					"daily_cap": {"amount": "0.00"}
+				},
+				"history": []
+			}`))
+			var decoded pkg.AccountSnapshot
+			Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+			Expect(decoded.Policy).To(Equal(snapshot.Policy))
+			Expect(decoded.Balance).To(Equal(snapshot.Balance))
+		})
+
+		It("refuses versions it doesn't know", func() {
+			var decoded pkg.AccountSnapshot
+			Expect(json.Unmarshal([]byte(`{"version": 99, "id": "1"}`), &decoded)).To(MatchError(pkg.SchemaVersionError))
+		})
+	})
+})
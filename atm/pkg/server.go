@@ -0,0 +1,241 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Requests other than authorize carry their session token as "Authorization: Bearer <token>".
+	SessionTokenScheme = "Bearer"
+	// The largest request body the server will read. Every request fits in a few hundred bytes.
+	MaxRequestBytes = 4096
+)
+
+var (
+	InvalidRequestError   = NewATMError("invalid_request", InputCategory, "Invalid request.")
+	MethodNotAllowedError = NewATMError("method_not_allowed", InputCategory, "Method not allowed.")
+
+	// The status code errors in each category are reported with.
+	CategoryStatusCodes = map[ErrorCategory]int{
+		AuthCategory:     http.StatusUnauthorized,
+		InputCategory:    http.StatusBadRequest,
+		FundsCategory:    http.StatusUnprocessableEntity,
+		DeviceCategory:   http.StatusServiceUnavailable,
+		InternalCategory: http.StatusInternalServerError,
+	}
+
+	// The status code errors are reported with when it isn't the one for their category, by code.
+	ErrorStatusCodes = map[string]int{
+		AccountInUseError.Code:     http.StatusConflict,
+		MethodNotAllowedError.Code: http.StatusMethodNotAllowed,
+		CancelledError.Code:        http.StatusServiceUnavailable,
+		TimeoutError.Code:          http.StatusGatewayTimeout,
+	}
+)
+
+// Create an HTTP handler that serves the atm as a JSON API. Amounts are sent and received as
+// objects with a decimal string and a currency code, such as {"amount": "40.00", "currency": "USD"};
+// requests may leave the currency out to use the account's. The API is described by the OpenAPI
+// document at /openapi.json.
+func NewServer(atm Atm) http.Handler {
+	s := &server{atm: atm, mux: http.NewServeMux()}
+	s.handle("/authorize", http.MethodPost, s.authorize)
+	s.handle("/withdraw", http.MethodPost, s.withdraw)
+	s.handle("/deposit", http.MethodPost, s.deposit)
+	s.handle("/balance", http.MethodGet, s.balance)
+	s.handle("/history", http.MethodGet, s.history)
+	s.handle("/logout", http.MethodPost, s.logout)
+	s.handle("/openapi.json", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(OpenApiDocument))
+	})
+	return s.mux
+}
+
+type server struct {
+	atm Atm
+	mux *http.ServeMux
+}
+
+func (s *server) handle(path, method string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAtmError(w, MethodNotAllowedError)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+type authorizeRequest struct {
+	Id  string `json:"id"`
+	Pin string `json:"pin"`
+}
+
+type authorizeResponse struct {
+	Token     string `json:"token"`
+	AccountId string `json:"account_id"`
+}
+
+type amountRequest struct {
+	Amount string `json:"amount"`
+	// For withdrawals of foreign cash. Defaults to the account's currency.
+	Currency string `json:"currency,omitempty"`
+}
+
+type balanceResponse struct {
+	Balance Amount `json:"balance"`
+}
+
+type withdrawResponse struct {
+	Requested   Amount      `json:"requested"`
+	Dispensed   Amount      `json:"dispensed"`
+	Balance     Amount      `json:"balance"`
+	Transaction Transaction `json:"transaction"`
+}
+
+type historyResponse struct {
+	Transactions []Transaction `json:"transactions"`
+}
+
+type logoutResponse struct {
+	AccountId string `json:"account_id"`
+}
+
+type errorResponse struct {
+	Error    string        `json:"error"`
+	Code     string        `json:"code"`
+	Category ErrorCategory `json:"category"`
+}
+
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	var request authorizeRequest
+	if !readRequest(w, r, &request) {
+		return
+	}
+	token, err := s.atm.Authorize(r.Context(), request.Id, request.Pin)
+	if err != nil {
+		writeAtmError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, authorizeResponse{Token: token, AccountId: request.Id})
+}
+
+func (s *server) withdraw(w http.ResponseWriter, r *http.Request) {
+	amount, ok := s.readAmount(w, r)
+	if !ok {
+		return
+	}
+	txn, err := s.atm.Withdraw(r.Context(), sessionToken(r), amount)
+	if err != nil {
+		writeAtmError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, withdrawResponse{
+		Requested:   amount,
+		Dispensed:   txn.CashDispensed(),
+		Balance:     txn.ClosingBalance(),
+		Transaction: *txn,
+	})
+}
+
+func (s *server) deposit(w http.ResponseWriter, r *http.Request) {
+	amount, ok := s.readAmount(w, r)
+	if !ok {
+		return
+	}
+	if err := s.atm.Deposit(r.Context(), sessionToken(r), amount); err != nil {
+		writeAtmError(w, err)
+		return
+	}
+	s.balance(w, r)
+}
+
+func (s *server) balance(w http.ResponseWriter, r *http.Request) {
+	balance, err := s.atm.Balance(r.Context(), sessionToken(r))
+	if err != nil {
+		writeAtmError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, balanceResponse{Balance: balance})
+}
+
+// Transactions are listed newest first, as in the text interface.
+func (s *server) history(w http.ResponseWriter, r *http.Request) {
+	history, err := s.atm.History(r.Context(), sessionToken(r))
+	if err != nil {
+		writeAtmError(w, err)
+		return
+	}
+	response := historyResponse{Transactions: make([]Transaction, 0, len(history))}
+	for i := len(history) - 1; i >= 0; i-- {
+		response.Transactions = append(response.Transactions, history[i])
+	}
+	writeResponse(w, http.StatusOK, response)
+}
+
+func (s *server) logout(w http.ResponseWriter, r *http.Request) {
+	accountId, err := s.atm.Logout(r.Context(), sessionToken(r))
+	if err != nil {
+		writeAtmError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, logoutResponse{AccountId: accountId})
+}
+
+// The session token from the request's Authorization header, or "" if there isn't one.
+func sessionToken(r *http.Request) string {
+	fields := strings.Fields(r.Header.Get("Authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], SessionTokenScheme) {
+		return ""
+	}
+	return fields[1]
+}
+
+// Decode the JSON request body, writing a bad request response if it can't be or is larger than
+// MaxRequestBytes.
+func readRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		writeAtmError(w, InvalidRequestError.WithMessage("Invalid request: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+func (s *server) readAmount(w http.ResponseWriter, r *http.Request) (Amount, bool) {
+	var request amountRequest
+	if !readRequest(w, r, &request) {
+		return ZeroAmount, false
+	}
+	amount, err := parseSessionAmount(r.Context(), s.atm, sessionToken(r), request.Amount, request.Currency)
+	if err != nil {
+		writeAtmError(w, err)
+		return ZeroAmount, false
+	}
+	return amount, true
+}
+
+// Only the error's message is sent, as given by UserMessage.
+func writeAtmError(w http.ResponseWriter, err error) {
+	atmErr := AsATMError(err)
+	status, ok := ErrorStatusCodes[atmErr.Code]
+	if !ok {
+		status = CategoryStatusCodes[atmErr.Category]
+	}
+	writeResponse(w, status, errorResponse{
+		Error:    atmErr.Message,
+		Code:     atmErr.Code,
+		Category: atmErr.Category,
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
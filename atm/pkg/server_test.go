@@ -0,0 +1,216 @@
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Server", func() {
+
+	const (
+		id  = "12345"
+		pin = "1234"
+	)
+
+	var (
+		atm    pkg.Atm
+		server *httptest.Server
+		clock  *pkg.ManualClock
+
+		ctx       = context.Background()
+		cassettes = []pkg.Cassette{
+			{Denomination: pkg.Dollars(50), Count: 10},
+			{Denomination: pkg.Dollars(20), Count: 10},
+		}
+	)
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account := pkg.NewAccount(clock, id, pin, pkg.Dollars(100), pkg.DefaultOverdraftPolicy)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		server = httptest.NewServer(pkg.NewServer(atm))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(atm.Close()).To(Succeed())
+	})
+
+	call := func(method, path, token string, body interface{}) (int, map[string]interface{}) {
+		var reader *bytes.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			Expect(err).To(BeNil())
+			reader = bytes.NewReader(data)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		request, err := http.NewRequest(method, server.URL+path, reader)
+		Expect(err).To(BeNil())
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.Header.Get("Content-Type")).To(Equal("application/json"))
+		var decoded map[string]interface{}
+		Expect(json.NewDecoder(response.Body).Decode(&decoded)).To(Succeed())
+		return response.StatusCode, decoded
+	}
+
+	authorize := func() string {
+		status, body := call(http.MethodPost, "/authorize", "", map[string]string{"id": id, "pin": pin})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body["account_id"]).To(Equal(id))
+		return body["token"].(string)
+	}
+
+	usd := func(amount string) map[string]interface{} {
+		return map[string]interface{}{"amount": amount, "currency": "USD"}
+	}
+
+	It("serves a session end to end", func() {
+		token := authorize()
+
+		status, body := call(http.MethodGet, "/balance", token, nil)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(map[string]interface{}{"balance": usd("100.00")}))
+
+		status, body = call(http.MethodPost, "/deposit", token, map[string]string{"amount": "20"})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body["balance"]).To(Equal(usd("120.00")))
+
+		status, body = call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "70"})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body["requested"]).To(Equal(usd("70.00")))
+		Expect(body["dispensed"]).To(Equal(usd("70.00")))
+		Expect(body["balance"]).To(Equal(usd("50.00")))
+		txn := body["transaction"].(map[string]interface{})
+		Expect(txn["version"]).To(Equal(float64(pkg.SchemaVersion)))
+		Expect(txn["type"]).To(Equal("withdrawal"))
+		Expect(txn["terminal_id"]).To(Equal("ATM-TEST"))
+		Expect(txn["dispensed"]).To(ConsistOf(
+			map[string]interface{}{"denomination": usd("50.00"), "count": 1.0},
+			map[string]interface{}{"denomination": usd("20.00"), "count": 1.0},
+		))
+
+		status, body = call(http.MethodGet, "/history", token, nil)
+		Expect(status).To(Equal(http.StatusOK))
+		history := body["transactions"].([]interface{})
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].(map[string]interface{})["amount"]).To(Equal(usd("-70.00")))
+		Expect(history[1].(map[string]interface{})["amount"]).To(Equal(usd("20.00")))
+
+		status, body = call(http.MethodPost, "/logout", token, nil)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body["account_id"]).To(Equal(id))
+
+		status, body = call(http.MethodGet, "/balance", token, nil)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+		Expect(body["error"]).To(Equal(pkg.AuthorizationRequiredError.Error()))
+	})
+
+	It("sends the currency with every amount", func() {
+		server.Close()
+		Expect(atm.Close()).To(Succeed())
+		account := pkg.NewAccount(clock, "67890", pin, pkg.MajorAmount(pkg.JPY, 1000), pkg.OverdraftPolicy{})
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 1, 0,
+			pkg.NewCassetteDispenser(pkg.Cassette{Denomination: pkg.MajorAmount(pkg.JPY, 1000), Count: 10}), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+		server = httptest.NewServer(pkg.NewServer(atm))
+
+		_, body := call(http.MethodPost, "/authorize", "", map[string]string{"id": "67890", "pin": pin})
+		_, body = call(http.MethodGet, "/balance", body["token"].(string), nil)
+		Expect(body["balance"]).To(Equal(map[string]interface{}{"amount": "1000", "currency": "JPY"}))
+	})
+
+	It("reports overdraft fees", func() {
+		token := authorize()
+		status, body := call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "120"})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body["balance"]).To(Equal(usd("-25.00")))
+		fees := body["transaction"].(map[string]interface{})["fees"].([]interface{})
+		Expect(fees).To(HaveLen(1))
+		Expect(fees[0].(map[string]interface{})["amount"]).To(Equal(usd("-5.00")))
+	})
+
+	It("maps errors to status codes", func() {
+		status, body := call(http.MethodPost, "/authorize", "", map[string]string{"id": id, "pin": "0000"})
+		Expect(status).To(Equal(http.StatusUnauthorized))
+		Expect(body["error"]).To(Equal(pkg.AuthorizationFailedError.Error()))
+
+		token := authorize()
+		status, _ = call(http.MethodPost, "/authorize", "", map[string]string{"id": id, "pin": pin})
+		Expect(status).To(Equal(http.StatusConflict))
+
+		status, body = call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "-20"})
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body["error"]).To(Equal(pkg.InvalidAmountError.Error()))
+		Expect(body["code"]).To(Equal("invalid_amount"))
+		Expect(body["category"]).To(Equal("input"))
+
+		status, _ = call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "twenty"})
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		status, _ = call(http.MethodPost, "/deposit", token, map[string]string{"value": "20"})
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		status, body = call(http.MethodPost, "/deposit", token, map[string]string{"amount": strings.Repeat("1", pkg.MaxRequestBytes)})
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body["error"]).To(ContainSubstring("too large"))
+
+		status, _ = call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "120"})
+		Expect(status).To(Equal(http.StatusOK))
+		status, body = call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "20"})
+		Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		Expect(body["error"]).To(Equal(pkg.AccountOverdrawnError.Error()))
+
+		Expect(atm.Close()).To(Succeed())
+		status, body = call(http.MethodGet, "/balance", token, nil)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body["error"]).To(Equal(pkg.AtmClosedError.Error()))
+	})
+
+	It("reports running out of cash as unavailable", func() {
+		token := authorize()
+		status, _ := call(http.MethodPost, "/deposit", token, map[string]string{"amount": "1000"})
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "700"})
+		Expect(status).To(Equal(http.StatusOK))
+		status, body := call(http.MethodPost, "/withdraw", token, map[string]string{"amount": "20"})
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body["error"]).To(Equal(pkg.NoMoneyError.Error()))
+	})
+
+	It("requires a session token", func() {
+		status, _ := call(http.MethodGet, "/balance", "", nil)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+		status, _ = call(http.MethodGet, "/balance", "not-a-token", nil)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("rejects the wrong method", func() {
+		status, _ := call(http.MethodGet, "/withdraw", "", nil)
+		Expect(status).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("serves its OpenAPI description", func() {
+		status, body := call(http.MethodGet, "/openapi.json", "", nil)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body["openapi"]).To(HavePrefix("3."))
+		Expect(body["paths"]).To(HaveKey("/withdraw"))
+	})
+})
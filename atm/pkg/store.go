@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	AccountNotFoundError = NewATMError("account_not_found", AuthCategory, "Account not found.")
+
+	_ AccountStore = new(memoryStore)
+)
+
+// Where the atm finds accounts. Accounts returned by Get are live: changes made through them are
+// only guaranteed to be kept once they've been passed back to Save.
+type AccountStore interface {
+	// Look up an account by id, returning AccountNotFoundError if there isn't one.
+	Get(id string) (Account, error)
+	// All accounts in the store, ordered by id.
+	List() ([]Account, error)
+	// Add the account to the store, or persist changes made to it other than through Post.
+	Save(account Account) error
+	// Durably record a transaction prepared by the account, then commit it to the account. If it
+	// can't be recorded, the account is left as it was, so a posting that failed never shows up in
+	// the balance.
+	Post(account Account, txn *Transaction) error
+	// Take exclusive access to an account, returning a function that releases it.
+	Lock(id string) (func(), error)
+}
+
+// A store that only keeps accounts in memory, so everything is lost on exit.
+func NewMemoryStore(accounts ...Account) AccountStore {
+	return &memoryStore{
+		accounts: Accounts(accounts...),
+		locks:    newAccountLocks(),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+type memoryStore struct {
+	accounts map[string]Account
+	locks    *accountLocks
+	mutex    *sync.Mutex
+}
+
+func (s *memoryStore) Get(id string) (Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, AccountNotFoundError
+	}
+	return account, nil
+}
+
+func (s *memoryStore) List() ([]Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sortedAccounts(s.accounts), nil
+}
+
+func (s *memoryStore) Save(account Account) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accounts[account.GetId()] = account
+	return nil
+}
+
+func (s *memoryStore) Post(account Account, txn *Transaction) error {
+	return account.Commit(txn)
+}
+
+func (s *memoryStore) Lock(id string) (func(), error) {
+	return s.locks.lock(id), nil
+}
+
+func sortedAccounts(accounts map[string]Account) []Account {
+	result := make([]Account, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, account)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetId() < result[j].GetId()
+	})
+	return result
+}
+
+// One mutex per account id, created on demand.
+type accountLocks struct {
+	locks map[string]*sync.Mutex
+	mutex *sync.Mutex
+}
+
+func newAccountLocks() *accountLocks {
+	return &accountLocks{
+		locks: make(map[string]*sync.Mutex),
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (l *accountLocks) lock(id string) func() {
+	l.mutex.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[id] = lock
+	}
+	l.mutex.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
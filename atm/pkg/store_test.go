@@ -0,0 +1,211 @@
+package pkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("AccountStore", func() {
+	var (
+		clock *pkg.ManualClock
+		dir   string
+		path  string
+	)
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		var err error
+		dir, err = ioutil.TempDir("", "accounts")
+		Expect(err).To(BeNil())
+		path = filepath.Join(dir, "accounts.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	newAccount := func(id string, balance pkg.Amount) pkg.Account {
+		return pkg.NewAccount(clock, id, "1234", balance, pkg.DefaultOverdraftPolicy)
+	}
+
+	behavesLikeAStore := func(open func(accounts ...pkg.Account) pkg.AccountStore) {
+		It("gets saved accounts", func() {
+			store := open(newAccount("2", pkg.Dollars(20)), newAccount("1", pkg.Dollars(10)))
+			account, err := store.Get("1")
+			Expect(err).To(BeNil())
+			Expect(account.Balance()).To(Equal(pkg.Dollars(10)))
+			_, err = store.Get("3")
+			Expect(err).To(Equal(pkg.AccountNotFoundError))
+			Expect(store.Save(newAccount("3", pkg.Dollars(30)))).To(Succeed())
+			accounts, err := store.List()
+			Expect(err).To(BeNil())
+			Expect(accounts).To(HaveLen(3))
+			Expect(accounts[0].GetId()).To(Equal("1"))
+			Expect(accounts[2].GetId()).To(Equal("3"))
+		})
+
+		It("locks accounts", func() {
+			store := open(newAccount("1", pkg.Dollars(10)))
+			unlock, err := store.Lock("1")
+			Expect(err).To(BeNil())
+			locked := make(chan bool)
+			go func() {
+				unlock, _ := store.Lock("1")
+				locked <- true
+				unlock()
+			}()
+			Consistently(locked).ShouldNot(Receive())
+			unlock()
+			Eventually(locked).Should(Receive())
+		})
+	}
+
+	Context("in memory", func() {
+		behavesLikeAStore(func(accounts ...pkg.Account) pkg.AccountStore {
+			return pkg.NewMemoryStore(accounts...)
+		})
+	})
+
+	Context("in a file", func() {
+		behavesLikeAStore(func(accounts ...pkg.Account) pkg.AccountStore {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			for _, account := range accounts {
+				Expect(store.Save(account)).To(Succeed())
+			}
+			return store
+		})
+
+		It("keeps balances and history across restarts", func() {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			account := pkg.NewAccount(clock, "1", "1234", pkg.Dollars(10), pkg.OverdraftPolicy{
+				Fees: pkg.TieredFee{{Over: pkg.ZeroAmount, Fee: pkg.Dollars(5)}},
+			})
+			txn, err := account.Transaction(pkg.Dollars(-20), pkg.TransactionDetails{
+				TerminalId: "ATM-1",
+				Dispensed:  pkg.Cassettes{{Denomination: pkg.Dollars(20), Count: 1}},
+			})
+			Expect(err).To(BeNil())
+			Expect(store.Save(account)).To(Succeed())
+
+			reopened, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			restored, err := reopened.Get("1")
+			Expect(err).To(BeNil())
+			Expect(restored.Authorize("1234")).To(BeTrue())
+			Expect(restored.Balance()).To(Equal(pkg.Dollars(-15)))
+			Expect(restored.History()).To(HaveLen(2))
+			Expect(restored.History()[0].Id).To(Equal(txn.Id))
+			Expect(restored.History()[0].Dispensed).To(Equal(txn.Dispensed))
+			Expect(restored.History()[1].ParentId).To(Equal(txn.Id))
+			Expect(restored.Snapshot().Policy).To(Equal(account.Snapshot().Policy))
+		})
+
+		It("keeps a hash of the PIN rather than the PIN itself", func() {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			Expect(store.Save(newAccount("1", pkg.Dollars(10)))).To(Succeed())
+			data, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(ContainSubstring(`"pin_hash": "sha256:`))
+			Expect(string(data)).NotTo(ContainSubstring(`"pin"`))
+		})
+
+		It("refuses files written without a schema version", func() {
+			Expect(ioutil.WriteFile(path, []byte(`[{"id": "1", "pin": "1234", "balance_cents": 1000}]`), 0600)).To(Succeed())
+			_, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(MatchError(pkg.StoreCorruptedError))
+			Expect(err).To(MatchError(pkg.SchemaVersionError))
+		})
+
+		It("keeps each account's currency", func() {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			account := pkg.NewAccount(clock, "1", "1234", pkg.MajorAmount(pkg.JPY, 5000), pkg.OverdraftPolicy{})
+			_, err = account.Transaction(pkg.MajorAmount(pkg.JPY, -1000), pkg.TransactionDetails{})
+			Expect(err).To(BeNil())
+			Expect(store.Save(account)).To(Succeed())
+
+			reopened, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			restored, err := reopened.Get("1")
+			Expect(err).To(BeNil())
+			Expect(restored.Currency()).To(Equal(pkg.JPY))
+			Expect(restored.Balance()).To(Equal(pkg.MajorAmount(pkg.JPY, 4000)))
+			Expect(restored.History()[0].Amount).To(Equal(pkg.MajorAmount(pkg.JPY, -1000)))
+		})
+
+		It("keeps the foreign cash and rate of conversions", func() {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			rate, err := pkg.ParseRate(pkg.EUR, pkg.USD, "1.1144825")
+			Expect(err).To(BeNil())
+			account := newAccount("1", pkg.Dollars(1000))
+			txn, err := account.Transaction(pkg.NewAmount(-111, -45), pkg.TransactionDetails{
+				Dispensed:  pkg.Cassettes{{Denomination: pkg.MajorAmount(pkg.EUR, 50), Count: 2}},
+				Conversion: &pkg.Conversion{Foreign: pkg.MajorAmount(pkg.EUR, 100), Home: pkg.NewAmount(111, 45), Rate: rate},
+			})
+			Expect(err).To(BeNil())
+			Expect(store.Save(account)).To(Succeed())
+
+			reopened, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			restored, err := reopened.Get("1")
+			Expect(err).To(BeNil())
+			Expect(restored.History()[0].Dispensed).To(Equal(txn.Dispensed))
+			Expect(restored.History()[0].Conversion).To(Equal(txn.Conversion))
+		})
+
+		It("refuses a file with amounts out of range", func() {
+			Expect(ioutil.WriteFile(path, []byte(`[{"version": 1, "id": "1", "balance": {"amount": "19999999999999999.99", "currency": "USD"}}]`), 0600)).To(Succeed())
+			_, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(MatchError(pkg.StoreCorruptedError))
+			Expect(err).To(MatchError(pkg.AmountRangeError))
+		})
+
+		It("doesn't leave temporary files behind", func() {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			Expect(store.Save(newAccount("1", pkg.Dollars(10)))).To(Succeed())
+			files, err := ioutil.ReadDir(dir)
+			Expect(err).To(BeNil())
+			names := []string{}
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			Expect(names).To(ConsistOf("accounts.json", "accounts.json.journal"))
+		})
+
+		It("appends postings to its journal rather than rewriting the file", func() {
+			store, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			account := newAccount("1", pkg.Dollars(10))
+			Expect(store.Save(account)).To(Succeed())
+			saved, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+
+			txn, err := account.Prepare(pkg.Dollars(5), pkg.TransactionDetails{})
+			Expect(err).To(BeNil())
+			Expect(store.Post(account, txn)).To(Succeed())
+			Expect(account.Balance()).To(Equal(pkg.Dollars(15)))
+			Expect(ioutil.ReadFile(path)).To(Equal(saved))
+			journal, err := os.Stat(path + ".journal")
+			Expect(err).To(BeNil())
+			Expect(journal.Size()).NotTo(BeZero())
+
+			reopened, err := pkg.OpenFileStore(clock, path)
+			Expect(err).To(BeNil())
+			restored, err := reopened.Get("1")
+			Expect(err).To(BeNil())
+			Expect(restored.Balance()).To(Equal(pkg.Dollars(15)))
+			Expect(restored.History()).To(HaveLen(1))
+		})
+	})
+})
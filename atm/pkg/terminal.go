@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	Prompt     = "> "
+	EndCommand = "end"
+)
+
+// Run the prompt loop for a customer at a terminal: print a prompt, read a command from in, write the
+// reply to out, and repeat. Notifications, such as a timeout warning, are written to out as they
+// happen. The loop stops at the end command, when the context is done, or when reading fails, in
+// which case the read error is returned. The customer's session is logged out on the way out.
+//
+// In the JSON format there's no prompt, so out is just one JSON response per line.
+func RunTerminal(ctx context.Context, atm Atm, format OutputFormat, in io.Reader, out io.Writer) error {
+	prompt := Prompt
+	if format == JsonFormat {
+		prompt = ""
+	}
+	writer := &terminalWriter{out: out, mutex: &sync.Mutex{}}
+	ui := NewInterface(atm, format, func(message string) {
+		if prompt == "" {
+			writer.printf("%s\n", message)
+		} else {
+			writer.printf("\n%s\n%s", message, prompt)
+		}
+	})
+	defer ui.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	lines := readLines(in, stop)
+	for {
+		writer.printf("%s", prompt)
+		select {
+		case <-ctx.Done():
+			if prompt != "" {
+				writer.printf("\n")
+			}
+			return nil
+		case line := <-lines:
+			if line.err != nil {
+				return line.err
+			}
+			response := ui.Respond(ctx, line.text)
+			if response.Command == EndCommand && response.Status == ResponseOk {
+				return nil
+			}
+			writer.printf("%s\n", response.Render(format))
+		}
+	}
+}
+
+// Serializes writes from the prompt loop and from notifications.
+type terminalWriter struct {
+	out   io.Writer
+	mutex *sync.Mutex
+}
+
+func (w *terminalWriter) printf(format string, args ...interface{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, _ = fmt.Fprintf(w.out, format, args...)
+}
+
+type terminalLine struct {
+	text string
+	err  error
+}
+
+// Read lines in the background so the prompt loop can stop while waiting for input. The last line
+// sent carries the error that stopped the reading. Reading also stops once stop is closed, though
+// a read already blocked only returns when the input does.
+func readLines(in io.Reader, stop chan struct{}) <-chan terminalLine {
+	lines := make(chan terminalLine)
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			text, err := reader.ReadString('\n')
+			select {
+			case lines <- terminalLine{text: text, err: err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
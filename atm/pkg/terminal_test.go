@@ -0,0 +1,97 @@
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rickducott/techproblems/atm/pkg"
+)
+
+var _ = Describe("Terminal", func() {
+
+	const (
+		id  = "12345"
+		pin = "1234"
+	)
+
+	var (
+		atm   pkg.Atm
+		clock *pkg.ManualClock
+
+		ctx       = context.Background()
+		amount    = pkg.Dollars(200)
+		cassettes = []pkg.Cassette{{Denomination: pkg.Dollars(20), Count: 100}}
+	)
+
+	BeforeEach(func() {
+		clock = pkg.NewManualClock(time.Date(2020, 8, 14, 12, 0, 0, 0, time.UTC))
+		account := pkg.NewAccount(clock, id, pin, amount, pkg.DefaultOverdraftPolicy)
+		var err error
+		atm, err = pkg.NewAtm(ctx, clock, "ATM-TEST", 120, 30, pkg.NewCassetteDispenser(cassettes...), pkg.NewMemoryStore(account), nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(atm.Close()).To(Succeed())
+	})
+
+	It("runs commands until told to end", func() {
+		in := strings.NewReader(fmt.Sprintf("authorize %s %s\nbalance\nend\nbalance\n", id, pin))
+		out := &bytes.Buffer{}
+		Expect(pkg.RunTerminal(ctx, atm, pkg.TextFormat, in, out)).To(Succeed())
+		Expect(out.String()).To(Equal("> " + pkg.AuthorizedMessage(id) + "\n> " + pkg.BalanceMessage(amount) + "\n> "))
+	})
+
+	It("writes one JSON response per line without prompts", func() {
+		in := strings.NewReader(fmt.Sprintf("authorize %s %s\nbalance\n", id, pin))
+		out := &bytes.Buffer{}
+		Expect(pkg.RunTerminal(ctx, atm, pkg.JsonFormat, in, out)).To(Equal(io.EOF))
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(MatchJSON(`{"command": "authorize", "status": "ok", "message": "12345 successfully authorized.", "account_id": "12345"}`))
+		Expect(lines[1]).To(MatchJSON(`{"command": "balance", "status": "ok", "message": "Current balance: $200.00", "balance": {"amount": "200.00", "currency": "USD"}}`))
+	})
+
+	It("stops when the input runs out, and logs out", func() {
+		in := strings.NewReader(fmt.Sprintf("authorize %s %s\n", id, pin))
+		Expect(pkg.RunTerminal(ctx, atm, pkg.TextFormat, in, &bytes.Buffer{})).To(Equal(io.EOF))
+		_, err := atm.Authorize(ctx, id, pin)
+		Expect(err).To(BeNil())
+	})
+
+	It("stops when the context is done", func() {
+		in, writer := io.Pipe()
+		defer writer.Close()
+		out := gbytes.NewBuffer()
+		terminalCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan error)
+		go func() {
+			stopped <- pkg.RunTerminal(terminalCtx, atm, pkg.TextFormat, in, out)
+		}()
+		Eventually(out).Should(gbytes.Say("> "))
+		cancel()
+		Eventually(stopped).Should(Receive(BeNil()))
+	})
+
+	It("prints notifications as they happen", func() {
+		in, writer := io.Pipe()
+		defer writer.Close()
+		out := gbytes.NewBuffer()
+		go func() {
+			_ = pkg.RunTerminal(ctx, atm, pkg.TextFormat, in, out)
+		}()
+		_, err := fmt.Fprintf(writer, "authorize %s %s\n", id, pin)
+		Expect(err).To(BeNil())
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta(pkg.AuthorizedMessage(id) + "\n> ")))
+		clock.Advance(90 * time.Second)
+		Eventually(out).Should(gbytes.Say(regexp.QuoteMeta("\n" + pkg.TimeoutWarningMessage(30*time.Second) + "\n> ")))
+	})
+})
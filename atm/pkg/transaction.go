@@ -1,27 +1,177 @@
 package pkg
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 )
 
+type TransactionType string
+
+const (
+	WithdrawalTransaction TransactionType = "withdrawal"
+	DepositTransaction    TransactionType = "deposit"
+	FeeTransaction        TransactionType = "fee"
+	TransferTransaction   TransactionType = "transfer"
+	ReversalTransaction   TransactionType = "reversal"
+	InterestTransaction   TransactionType = "interest"
+)
+
+// A single posting to an account. Fees are posted as their own transactions straight after the
+// transaction that incurred them (and point back to it through ParentId), so each transaction's
+// amount always reconciles with the change in balance.
 type Transaction struct {
-	Date      time.Time
-	Amount    Amount
-	Balance   Amount
-	Overdraft bool
+	Id         string
+	Type       TransactionType
+	ParentId   string
+	TerminalId string
+	Memo       string
+	Date       time.Time
+	Amount     Amount
+	Balance    Amount
+	Overdraft  bool
+	// The notes paid out by a withdrawal. On the reversal of a withdrawal, the notes that were held
+	// back after all.
+	Dispensed Cassettes
+	// For withdrawals of foreign cash, the foreign amount dispensed and the rate it was charged at.
+	// Amount is then what was debited in the account's currency.
+	Conversion *Conversion
+	// The fee postings made along with this transaction. Only populated on the transaction returned
+	// by Account.Transaction; in the account history each fee is its own entry.
+	Fees []Transaction
+	// Reversals of this transaction or its fees, for withdrawals the atm couldn't fully dispense.
+	// Like Fees, only populated on the transaction returned by Atm.Withdraw.
+	Reversals []Transaction
+}
+
+// Everything about a transaction that the caller decides, rather than the account.
+type TransactionDetails struct {
+	// Defaults to a withdrawal or deposit depending on the sign of the amount.
+	Type       TransactionType
+	ParentId   string
+	TerminalId string
+	Memo       string
+	Dispensed  Cassettes
+	Conversion *Conversion
 }
 
-func NewTransaction(amount, balance Amount) Transaction {
+func NewTransaction(clock Clock, amount, balance Amount, details TransactionDetails) Transaction {
+	txnType := details.Type
+	if txnType == "" {
+		txnType = DepositTransaction
+		if ZeroAmount.GreaterThan(amount) {
+			txnType = WithdrawalTransaction
+		}
+	}
 	overdraft := ZeroAmount.GreaterThan(amount) && ZeroAmount.GreaterThan(balance)
 	return Transaction{
-		Date:      time.Now(),
-		Amount:    amount,
-		Balance:   balance,
-		Overdraft: overdraft,
+		Id:         NewTransactionId(),
+		Type:       txnType,
+		ParentId:   details.ParentId,
+		TerminalId: details.TerminalId,
+		Memo:       details.Memo,
+		Date:       clock.Now(),
+		Amount:     amount,
+		Balance:    balance,
+		Overdraft:  overdraft,
+		Dispensed:  details.Dispensed,
+		Conversion: details.Conversion,
+	}
+}
+
+// Generate a random, unique transaction id.
+func NewTransactionId() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// The account balance once this transaction, its fees and any reversals have been posted.
+func (t Transaction) ClosingBalance() Amount {
+	if len(t.Reversals) > 0 {
+		return t.Reversals[len(t.Reversals)-1].Balance
+	}
+	if len(t.Fees) > 0 {
+		return t.Fees[len(t.Fees)-1].Balance
+	}
+	return t.Balance
+}
+
+// The entries the transaction makes in the account history: the transaction itself, then each of
+// its fees.
+func (t Transaction) Postings() []Transaction {
+	posting := t
+	posting.Fees = nil
+	posting.Reversals = nil
+	return append([]Transaction{posting}, t.Fees...)
+}
+
+// The amount that actually changed hands: the transaction's amount less anything reversed.
+func (t Transaction) NetAmount() Amount {
+	amount := t.Amount
+	for _, reversal := range t.Reversals {
+		if reversal.ParentId == t.Id {
+			amount = amount.Add(reversal.Amount)
+		}
+	}
+	return amount
+}
+
+// The cash that came out for a withdrawal, in the currency it came out in. That's the net amount,
+// unless the cash was foreign, in which case it's the foreign amount less any notes held back.
+func (t Transaction) CashDispensed() Amount {
+	if t.Conversion == nil {
+		return t.NetAmount().Abs()
+	}
+	cash := t.Conversion.Foreign
+	for _, reversal := range t.Reversals {
+		if reversal.ParentId == t.Id {
+			cash = cash.Subtract(reversal.Dispensed.Total())
+		}
+	}
+	return cash
+}
+
+// Whether the given fee was refunded by one of this transaction's reversals.
+func (t Transaction) Refunded(fee Transaction) bool {
+	for _, reversal := range t.Reversals {
+		if reversal.ParentId == fee.Id {
+			return true
+		}
 	}
+	return false
 }
 
 func (t Transaction) String() string {
-	return fmt.Sprintf("%v %v %v", t.Date.Format("2006-01-02 15:04:05"), t.Amount, t.Balance)
+	return t.Format(PlainAmountFormat)
+}
+
+// The transaction as a line of its history, with amounts in the given format.
+func (t Transaction) Format(format AmountFormat) string {
+	fields := []string{
+		t.Date.Format("2006-01-02 15:04:05"),
+		t.Id,
+		string(t.Type),
+		t.Amount.Format(format),
+		t.Balance.Format(format),
+	}
+	if t.TerminalId != "" {
+		fields = append(fields, "terminal="+t.TerminalId)
+	}
+	if t.ParentId != "" {
+		fields = append(fields, "parent="+t.ParentId)
+	}
+	if t.Memo != "" {
+		fields = append(fields, fmt.Sprintf("memo=%q", t.Memo))
+	}
+	if t.Conversion != nil {
+		fields = append(fields,
+			fmt.Sprintf("foreign=%s%s", t.Conversion.Foreign.Currency(), t.Conversion.Foreign),
+			"rate="+t.Conversion.Rate.String())
+	}
+	return strings.Join(fields, " ")
 }